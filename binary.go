@@ -0,0 +1,474 @@
+package apidoc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"github.com/golang/snappy"
+)
+
+// Binary payloads written by MarshalBinary start with a header:
+//
+//	magic       [4]byte  0xA9 'D' 'O' 'C'
+//	version     uint8    format version, currently 1
+//	codec       uint8    ID of the Codec compressing the encoded Document
+//	flags       uint8    feature flags
+//
+// When binaryFlagDictionary is set the header is followed by the uvarint ID
+// of the Dictionary seeding the key table. When binaryFlagKeyTable is set
+// each Document key is written once and then referred to by its index.
+//
+// When binaryFlagChecksum is set the payload ends with a little endian
+// uint32 CRC-32C (Castagnoli) of the uncompressed encoded Document.
+//
+// When binaryFlagIndexed is set the Document is written with the indexed
+// layout described in indexed.go.
+//
+// When binaryFlagStream is set the payload was written by an Encoder, the
+// codec compresses a sequence of Documents each preceded by its uvarint
+// length and followed by its checksum.
+//
+// Older payloads start with a little endian uint32 instead, either serBinary
+// followed by a snappy stream, or the CRC32 of the legacy JSON encoding that
+// follows. The version can never be '{' so a legacy JSON payload whose CRC
+// happens to equal the magic is still recognized.
+
+var binaryMagic = [4]byte{0xA9, 'D', 'O', 'C'}
+
+const (
+	binaryHeaderSize = 7
+	binaryVersion    = 1
+)
+
+// errors returned by UnmarshalBinary
+var (
+	// ErrUnsupportedVersion is returned for payloads written with a newer
+	// version of the binary format, or with feature flags this version does
+	// not know
+	ErrUnsupportedVersion = errors.New("unsupported binary format version")
+	// ErrCorrupt is returned for payloads that fail their checksum or cannot
+	// be decoded, e.g. truncated cache entries
+	ErrCorrupt = errors.New("corrupt binary payload")
+	// ErrUnknownCodec is returned for payloads compressed with a Codec that
+	// has not been registered
+	ErrUnknownCodec = errors.New("unknown binary codec")
+	// ErrUnknownDictionary is returned for payloads written with a
+	// Dictionary that has not been registered
+	ErrUnknownDictionary = errors.New("unknown binary dictionary")
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+const checksumSize = 4
+
+// binaryFlags are the feature flags of a binary payload
+type binaryFlags uint8
+
+const (
+	binaryFlagChecksum binaryFlags = 1 << iota
+	binaryFlagKeyTable
+	binaryFlagDictionary
+	binaryFlagStream
+	binaryFlagIndexed
+)
+
+// binaryFlagsKnown are the flags understood by this version
+const binaryFlagsKnown = binaryFlagChecksum | binaryFlagKeyTable | binaryFlagDictionary |
+	binaryFlagStream | binaryFlagIndexed
+
+type binaryHeader struct {
+	version uint8
+	codec   Codec
+	flags   binaryFlags
+	dict    *Dictionary
+}
+
+func (h binaryHeader) appendTo(b []byte) []byte {
+	b = append(b, binaryMagic[:]...)
+	b = append(b, h.version, h.codec.ID(), byte(h.flags))
+	if h.flags&binaryFlagDictionary != 0 {
+		b = binary.AppendUvarint(b, uint64(h.dict.ID))
+	}
+	return b
+}
+
+// hasBinaryHeader reports if data starts with a versioned header
+func hasBinaryHeader(data []byte) bool {
+	return len(data) >= binaryHeaderSize &&
+		bytes.Equal(data[:len(binaryMagic)], binaryMagic[:]) &&
+		data[len(binaryMagic)] != '{'
+}
+
+// parseBinaryHeader returns the header and its size
+func parseBinaryHeader(data []byte) (binaryHeader, int, error) {
+	h := binaryHeader{
+		version: data[4],
+		flags:   binaryFlags(data[6]),
+	}
+	if h.version == 0 || h.version > binaryVersion {
+		return h, 0, fmt.Errorf("%w: %d", ErrUnsupportedVersion, h.version)
+	}
+	if h.flags&^binaryFlagsKnown != 0 {
+		return h, 0, fmt.Errorf("%w: flags %08b", ErrUnsupportedVersion, h.flags)
+	}
+	codec, ok := codecByID(data[5])
+	if !ok {
+		return h, 0, fmt.Errorf("%w: %d", ErrUnknownCodec, data[5])
+	}
+	h.codec = codec
+
+	n := binaryHeaderSize
+	if h.flags&binaryFlagDictionary != 0 {
+		id, l := binary.Uvarint(data[n:])
+		if l <= 0 || id > math.MaxUint32 {
+			return h, 0, fmt.Errorf("%w: invalid dictionary ID", ErrCorrupt)
+		}
+		n += l
+		if h.dict, ok = dictionaryByID(uint32(id)); !ok {
+			return h, 0, fmt.Errorf("%w: %d", ErrUnknownDictionary, id)
+		}
+	}
+	return h, n, nil
+}
+
+type serializationType uint32
+
+const (
+	serInvalid serializationType = iota
+	serBinary                    // this is the snappy encoded version
+)
+
+// default limits of DecodeOptions, used by UnmarshalBinary
+const (
+	DefaultMaxStringLength = 16 << 20
+	DefaultMaxDepth        = 1000
+	DefaultMaxElements     = 1 << 24
+	DefaultMaxBytes        = 256 << 20
+)
+
+// DecodeOptions limits the resources used to decode a binary payload, so
+// that a corrupt or malicious payload cannot exhaust memory or the stack.
+// Limits which are zero use their default, and negative limits are disabled.
+type DecodeOptions struct {
+	// MaxStringLength is the longest string or key in bytes
+	MaxStringLength int
+	// MaxDepth is the deepest nesting of Documents and lists
+	MaxDepth int
+	// MaxElements is the total number of Document keys and list items
+	MaxElements int
+	// MaxBytes is the most bytes of encoded Document, after decompression
+	MaxBytes int64
+}
+
+func (o DecodeOptions) withDefaults() DecodeOptions {
+	limit := func(v, def int) int {
+		switch {
+		case v == 0:
+			return def
+		case v < 0:
+			return math.MaxInt
+		}
+		return v
+	}
+	o.MaxStringLength = limit(o.MaxStringLength, DefaultMaxStringLength)
+	o.MaxDepth = limit(o.MaxDepth, DefaultMaxDepth)
+	o.MaxElements = limit(o.MaxElements, DefaultMaxElements)
+	switch {
+	case o.MaxBytes == 0:
+		o.MaxBytes = DefaultMaxBytes
+	case o.MaxBytes < 0:
+		o.MaxBytes = math.MaxInt64
+	}
+	return o
+}
+
+// LimitError is returned when decoding a payload exceeds one of the limits
+// of DecodeOptions
+type LimitError struct {
+	// Limit is the name of the DecodeOptions field, e.g. MaxDepth
+	Limit string
+	Max   int64
+	// Offset in the encoded Document at which the limit was exceeded
+	Offset int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("binary payload exceeds %s of %d at offset %d", e.Limit, e.Max, e.Offset)
+}
+
+// UnmarshalBinary implements binary decoding, using the default limits of
+// DecodeOptions
+func (d *Document) UnmarshalBinary(data []byte) error {
+	return DecodeOptions{}.Unmarshal(data, d)
+}
+
+// Unmarshal decodes a payload written by MarshalBinary or
+// EncodeOptions.Marshal into d, failing with a *LimitError if the payload
+// exceeds the limits. The checksum is verified before decoding, so corrupt
+// payloads with a checksum fail with ErrCorrupt.
+func (o DecodeOptions) Unmarshal(data []byte, d *Document) error {
+	if hasBinaryHeader(data) {
+		return o.unmarshalVersioned(data, d)
+	}
+	var oldcrc uint32
+	buf := bytes.NewBuffer(data)
+	err := binary.Read(buf, binary.LittleEndian, &oldcrc)
+	if err != nil {
+		return fmt.Errorf("%w: binary.Read of checksum failed: %s", ErrCorrupt, err.Error())
+	}
+	remdata := buf.Bytes()
+	switch serializationType(oldcrc) {
+	case serBinary:
+		return o.decodeFrom(snappy.NewReader(bytes.NewReader(remdata)), nil, d)
+	default:
+		// must be legacy json then
+	}
+	if limit := o.withDefaults().MaxBytes; int64(len(remdata)) > limit {
+		return &LimitError{Limit: "MaxBytes", Max: limit, Offset: limit}
+	}
+	if crc32.ChecksumIEEE(remdata) != oldcrc {
+		return fmt.Errorf("checksum does not match - unmarshaling: %w", ErrCorrupt)
+	}
+	if err := json.Unmarshal(remdata, d); err != nil {
+		return fmt.Errorf("%w: %s", ErrCorrupt, err.Error())
+	}
+	return nil
+}
+
+// payloadBody parses the header of a versioned payload holding a single
+// Document, returning the header, the compressed body and its checksum
+func payloadBody(data []byte) (binaryHeader, []byte, uint32, error) {
+	h, n, err := parseBinaryHeader(data)
+	if err != nil {
+		return h, nil, 0, err
+	}
+	if h.flags&binaryFlagStream != 0 {
+		return h, nil, 0, errors.New("binary payload is a stream of Documents, it is read with a Decoder")
+	}
+	body := data[n:]
+
+	var want uint32
+	if h.flags&binaryFlagChecksum != 0 {
+		if len(body) < checksumSize {
+			return h, nil, 0, fmt.Errorf("%w: missing checksum", ErrCorrupt)
+		}
+		want = binary.LittleEndian.Uint32(body[len(body)-checksumSize:])
+		body = body[:len(body)-checksumSize]
+	}
+	return h, body, want, nil
+}
+
+// decodeError returns the error for a payload that failed to decode, limits
+// are returned as is and anything else is corrupt
+func decodeError(err error) error {
+	var limit *LimitError
+	if errors.As(err, &limit) {
+		return limit
+	}
+	if errors.Is(err, ErrCorrupt) {
+		return err
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("%w: %s", ErrCorrupt, err.Error())
+}
+
+func (o DecodeOptions) unmarshalVersioned(data []byte, d *Document) error {
+	h, body, want, err := payloadBody(data)
+	if err != nil {
+		return err
+	}
+	if h.flags&binaryFlagIndexed != 0 {
+		lazy, err := o.lazyDocument(h, body, want)
+		if err != nil {
+			return err
+		}
+		doc, err := lazy.Document()
+		if err != nil {
+			return err
+		}
+		*d = doc
+		return nil
+	}
+
+	var r io.Reader
+	if h.flags&binaryFlagChecksum != 0 {
+		if body, err = o.readBody(h, body, want); err != nil {
+			return err
+		}
+		r = bytes.NewReader(body)
+	} else if r, err = h.codec.NewReader(bytes.NewReader(body)); err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrCorrupt, h.codec.Name(), err.Error())
+	}
+	return o.decodeFrom(r, h.dict, d)
+}
+
+// readBody returns the uncompressed body of a payload, verifying its
+// checksum when it has one
+func (o DecodeOptions) readBody(h binaryHeader, body []byte, want uint32) ([]byte, error) {
+	o = o.withDefaults()
+	if h.codec != CodecNone {
+		r, err := h.codec.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrCorrupt, h.codec.Name(), err.Error())
+		}
+		if body, err = io.ReadAll(io.LimitReader(r, o.MaxBytes+1)); err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrCorrupt, h.codec.Name(), err.Error())
+		}
+	}
+	if int64(len(body)) > o.MaxBytes {
+		return nil, &LimitError{Limit: "MaxBytes", Max: o.MaxBytes, Offset: o.MaxBytes}
+	}
+	if h.flags&binaryFlagChecksum != 0 {
+		if err := verifyChecksum(body, want); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// verifyChecksum compares the CRC-32C of an uncompressed Document with the
+// one stored in the payload. Checksums are verified before decoding, so
+// that corruption is reported as ErrCorrupt rather than as whatever limit
+// it happens to break.
+func verifyChecksum(body []byte, want uint32) error {
+	if got := crc32.Checksum(body, castagnoliTable); got != want {
+		return fmt.Errorf("%w: checksum %08x does not match %08x", ErrCorrupt, got, want)
+	}
+	return nil
+}
+
+// decodeFrom decodes the single Document of r into d
+func (o DecodeOptions) decodeFrom(r io.Reader, dict *Dictionary, d *Document) error {
+	doc, err := decodeDocumentFrom(newDecoder(r, dict, o))
+	if err != nil {
+		return decodeError(err)
+	}
+	// anything left after the Document means the payload was tampered with
+	var extra [1]byte
+	if _, err := io.ReadFull(r, extra[:]); err != io.EOF {
+		return fmt.Errorf("%w: trailing data after Document", ErrCorrupt)
+	}
+	*d = doc
+	return nil
+}
+
+func decodeDocumentFrom(dec *decoder) (Document, error) {
+	val, err := dec.decodeValue()
+	if err != nil {
+		return nil, err
+	}
+	doc, ok := val.(Document)
+	if !ok {
+		return nil, fmt.Errorf("expected Document got %T", val)
+	}
+	return doc, nil
+}
+
+// EncodeOptions controls the binary encoding written by Marshal
+type EncodeOptions struct {
+	// Codec compresses the encoded Document, CodecSnappy when not set
+	Codec Codec
+	// Checksum appends a CRC-32C of the encoded Document which is verified
+	// by UnmarshalBinary
+	Checksum bool
+	// KeyTable writes each key once, referring to it by index afterwards
+	KeyTable bool
+	// Dictionary seeds the key table with a registered Dictionary, it
+	// implies KeyTable
+	Dictionary *Dictionary
+	// Indexed writes the Document with offset tables, so that it can be
+	// read with a LazyDocument. It cannot be used with a key table or by
+	// an Encoder.
+	Indexed bool
+}
+
+// MarshalBinary allows documents to be stored in cache, it writes a snappy
+// compressed payload with a key table and a checksum
+func (d Document) MarshalBinary() ([]byte, error) {
+	return EncodeOptions{Checksum: true, KeyTable: true}.Marshal(d)
+}
+
+// header returns the header of payloads written with the options
+func (o EncodeOptions) header() (binaryHeader, error) {
+	h := binaryHeader{version: binaryVersion, codec: o.Codec}
+	if h.codec == nil {
+		h.codec = CodecSnappy
+	}
+	if o.Checksum {
+		h.flags |= binaryFlagChecksum
+	}
+	if o.Indexed {
+		if o.KeyTable || o.Dictionary != nil {
+			return h, errors.New("the indexed layout cannot be used with a key table")
+		}
+		h.flags |= binaryFlagIndexed
+	}
+	if o.KeyTable || o.Dictionary != nil {
+		h.flags |= binaryFlagKeyTable
+	}
+	if o.Dictionary != nil {
+		if dict, ok := dictionaryByID(o.Dictionary.ID); !ok || dict != o.Dictionary {
+			return h, fmt.Errorf("%w: %d is not registered", ErrUnknownDictionary, o.Dictionary.ID)
+		}
+		h.flags |= binaryFlagDictionary
+		h.dict = o.Dictionary
+	}
+	return h, nil
+}
+
+// encoder returns an encoder writing Documents to w as described by h
+func (h binaryHeader) encoder(w io.Writer) encoder {
+	e := encoder{w: w, compactInts: true, dict: h.dict}
+	if h.flags&binaryFlagKeyTable != 0 {
+		e.keys = make(map[string]uint64)
+	}
+	return e
+}
+
+// Marshal returns the binary encoding of the Document, which can be decoded
+// with UnmarshalBinary
+func (o EncodeOptions) Marshal(d Document) ([]byte, error) {
+	h, err := o.header()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(h.appendTo(make([]byte, 0, 512*len(d))))
+	sw := h.codec.NewWriter(buf)
+
+	var w io.Writer = sw
+	var sum hash.Hash32
+	if o.Checksum {
+		sum = crc32.New(castagnoliTable)
+		w = io.MultiWriter(sw, sum)
+	}
+	if h.flags&binaryFlagIndexed != 0 {
+		var body bytes.Buffer
+		if err := encodeIndexedDocument(&body, d); err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(body.Bytes()); err != nil {
+			return nil, err
+		}
+	} else {
+		e := h.encoder(w)
+		if err := e.encodeDocument(d); err != nil {
+			return nil, err
+		}
+	}
+	if err := sw.Close(); err != nil {
+		return nil, err
+	}
+	if sum != nil {
+		buf.Write(binary.LittleEndian.AppendUint32(nil, sum.Sum32()))
+	}
+	return buf.Bytes(), nil
+}
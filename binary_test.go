@@ -0,0 +1,295 @@
+package apidoc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+// legacySnappyPayload returns the serBinary encoding written by older
+// versions of MarshalBinary
+func legacySnappyPayload(t *testing.T, doc Document) []byte {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	ok(t, binary.Write(buf, binary.LittleEndian, uint32(serBinary)))
+	w := snappy.NewBufferedWriter(buf)
+	ok(t, encodeDocument(w, doc, false))
+	ok(t, w.Close())
+	return buf.Bytes()
+}
+
+// legacyJSONPayload returns the CRC prefixed JSON encoding written by the
+// oldest versions of MarshalBinary
+func legacyJSONPayload(t *testing.T, doc Document) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(doc)
+	ok(t, err)
+	buf := new(bytes.Buffer)
+	ok(t, binary.Write(buf, binary.LittleEndian, crc32.ChecksumIEEE(data)))
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+func TestMarshalBinaryHeader(t *testing.T) {
+	doc := loadDeparture(t)
+	data, err := doc.MarshalBinary()
+	ok(t, err)
+	equals(t, []byte{0xA9, 'D', 'O', 'C', binaryVersion, CodecSnappy.ID(), byte(binaryFlagChecksum | binaryFlagKeyTable)}, data[:binaryHeaderSize])
+
+	var doc2 Document
+	ok(t, doc2.UnmarshalBinary(data))
+	assert(t, doc.Equal(doc2), "departure should round trip")
+
+	// uncompressed payloads
+	h := binaryHeader{version: binaryVersion, codec: CodecNone}
+	buf := bytes.NewBuffer(h.appendTo(nil))
+	ok(t, encodeDocument(buf, doc, false))
+	var doc3 Document
+	ok(t, doc3.UnmarshalBinary(buf.Bytes()))
+	assert(t, doc.Equal(doc3), "uncompressed departure should round trip")
+}
+
+func TestUnmarshalBinaryLegacy(t *testing.T) {
+	doc := loadDeparture(t)
+
+	var doc2 Document
+	ok(t, doc2.UnmarshalBinary(legacySnappyPayload(t, doc)))
+	assert(t, doc.Equal(doc2), "legacy snappy payload should decode")
+
+	var doc3 Document
+	legacy := legacyJSONPayload(t, doc)
+	ok(t, doc3.UnmarshalBinary(legacy))
+	assert(t, doc.Equal(doc3), "legacy JSON payload should decode")
+
+	legacy[len(legacy)-2] = ' '
+	err := doc3.UnmarshalBinary(legacy)
+	assert(t, errors.Is(err, ErrCorrupt), "expected ErrCorrupt got %v", err)
+
+	// a legacy JSON payload whose CRC collides with the magic
+	collision := append(append([]byte{}, binaryMagic[:]...), []byte(`{"a":1}`)...)
+	err = doc3.UnmarshalBinary(collision)
+	assert(t, err != nil && !errors.Is(err, ErrUnsupportedVersion), "expected a legacy checksum error got %v", err)
+
+	err = doc3.UnmarshalBinary([]byte{1, 2})
+	assert(t, errors.Is(err, ErrCorrupt), "expected ErrCorrupt for short payload got %v", err)
+
+	// a legacy JSON payload whose checksum matches but is not JSON
+	invalid := binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE([]byte(`{"a":`)))
+	err = doc3.UnmarshalBinary(append(invalid, `{"a":`...))
+	assert(t, errors.Is(err, ErrCorrupt), "expected ErrCorrupt got %v", err)
+
+	// legacy snappy payloads are checked like versioned ones
+	snappyLegacy := legacySnappyPayload(t, sampleDoc())
+	err = doc3.UnmarshalBinary(snappyLegacy[:len(snappyLegacy)-3])
+	assert(t, errors.Is(err, ErrCorrupt), "expected ErrCorrupt for truncated payload got %v", err)
+	buf := new(bytes.Buffer)
+	ok(t, binary.Write(buf, binary.LittleEndian, uint32(serBinary)))
+	w := snappy.NewBufferedWriter(buf)
+	ok(t, encodeDocument(w, sampleDoc(), false))
+	ok(t, encodeDocument(w, sampleDoc(), false))
+	ok(t, w.Close())
+	err = doc3.UnmarshalBinary(buf.Bytes())
+	assert(t, errors.Is(err, ErrCorrupt), "expected ErrCorrupt for trailing data got %v", err)
+}
+
+func TestUnmarshalBinaryUnsupported(t *testing.T) {
+	data, err := sampleDoc().MarshalBinary()
+	ok(t, err)
+
+	future := append([]byte{}, data...)
+	future[4] = binaryVersion + 1
+	var doc Document
+	err = doc.UnmarshalBinary(future)
+	assert(t, errors.Is(err, ErrUnsupportedVersion), "expected ErrUnsupportedVersion got %v", err)
+
+	unknown := append([]byte{}, data...)
+	unknown[5] = 250
+	err = doc.UnmarshalBinary(unknown)
+	assert(t, errors.Is(err, ErrUnknownCodec), "expected ErrUnknownCodec got %v", err)
+
+	flags := append([]byte{}, data...)
+	flags[6] = 0x80
+	err = doc.UnmarshalBinary(flags)
+	assert(t, errors.Is(err, ErrUnsupportedVersion), "expected ErrUnsupportedVersion for unknown flags got %v", err)
+}
+
+func TestUnmarshalBinaryChecksum(t *testing.T) {
+	doc := loadDeparture(t)
+	data, err := doc.MarshalBinary()
+	ok(t, err)
+
+	corrupt := func(data []byte, at int) error {
+		bad := append([]byte{}, data...)
+		bad[at] ^= 0xFF
+		var doc Document
+		return doc.UnmarshalBinary(bad)
+	}
+	// trailer, compressed body and truncation
+	for _, at := range []int{len(data) - 1, len(data) - checksumSize - 1, binaryHeaderSize + 20, len(data) / 2} {
+		err = corrupt(data, at)
+		assert(t, errors.Is(err, ErrCorrupt), "expected ErrCorrupt flipping byte %d got %v", at, err)
+	}
+	var doc2 Document
+	err = doc2.UnmarshalBinary(data[:len(data)-10])
+	assert(t, errors.Is(err, ErrCorrupt), "expected ErrCorrupt for truncated payload got %v", err)
+	err = doc2.UnmarshalBinary(data[:binaryHeaderSize+2])
+	assert(t, errors.Is(err, ErrCorrupt), "expected ErrCorrupt for missing checksum got %v", err)
+	assert(t, doc2 == nil, "document should not be set on error")
+
+	// uncompressed payloads rely on the checksum alone
+	h := binaryHeader{version: binaryVersion, codec: CodecNone, flags: binaryFlagChecksum}
+	buf := bytes.NewBuffer(h.appendTo(nil))
+	ok(t, encodeDocument(buf, doc, false))
+	buf.Write(binary.LittleEndian.AppendUint32(nil, crc32.Checksum(buf.Bytes()[binaryHeaderSize:], castagnoliTable)))
+	plain := buf.Bytes()
+	ok(t, doc2.UnmarshalBinary(plain))
+	assert(t, doc.Equal(doc2), "uncompressed departure should round trip")
+	idx := bytes.Index(plain, []byte("Zimbabwe"))
+	err = corrupt(plain, idx)
+	assert(t, errors.Is(err, ErrCorrupt), "expected ErrCorrupt got %v", err)
+
+	// a corrupt length is caught by the checksum, not by the limits
+	var le *LimitError
+	idx = bytes.Index(plain, append(binary.LittleEndian.AppendUint64([]byte{byte(encodeTypeString)}, 8), "Zimbabwe"...))
+	err = corrupt(plain, idx+4)
+	assert(t, errors.Is(err, ErrCorrupt) && !errors.As(err, &le), "expected checksum error got %v", err)
+}
+
+func TestEncodeOptionsNoChecksum(t *testing.T) {
+	doc := loadDeparture(t)
+	data, err := EncodeOptions{}.Marshal(doc)
+	ok(t, err)
+	equals(t, byte(0), data[6])
+	single := Document{"id": "733048"}
+	withSum, err := single.MarshalBinary()
+	ok(t, err)
+	without, err := EncodeOptions{KeyTable: true}.Marshal(single)
+	ok(t, err)
+	equals(t, len(withSum), len(without)+checksumSize)
+
+	var doc2 Document
+	ok(t, doc2.UnmarshalBinary(data))
+	assert(t, doc.Equal(doc2), "departure should round trip")
+}
+
+// rawPayload returns an uncompressed payload without checksum holding the
+// given encoding
+func rawPayload(encoded ...byte) []byte {
+	h := binaryHeader{version: binaryVersion, codec: CodecNone}
+	return append(h.appendTo(nil), encoded...)
+}
+
+func TestDecodeOptionsLimits(t *testing.T) {
+	limitErr := func(t *testing.T, err error, limit string) {
+		t.Helper()
+		var le *LimitError
+		assert(t, errors.As(err, &le), "expected LimitError got %v", err)
+		equals(t, limit, le.Limit)
+	}
+
+	t.Run("depth", func(t *testing.T) {
+		var encoded []byte
+		encoded = append(encoded, byte(encodeTypeDocumentStart), byte(encodeTypeString))
+		encoded = append(encoded, 1, 0, 0, 0, 0, 0, 0, 0, 'a')
+		for i := 0; i < DefaultMaxDepth+1; i++ {
+			encoded = append(encoded, byte(encodeTypeListStart))
+		}
+		for i := 0; i < DefaultMaxDepth+1; i++ {
+			encoded = append(encoded, byte(encodeTypeListEnd))
+		}
+		encoded = append(encoded, byte(encodeTypeDocumentEnd))
+		data := rawPayload(encoded...)
+
+		var doc Document
+		limitErr(t, doc.UnmarshalBinary(data), "MaxDepth")
+		ok(t, DecodeOptions{MaxDepth: -1}.Unmarshal(data, &doc))
+		_, found := doc.GetPath("a")
+		assert(t, found, "decoded document should have key a")
+	})
+
+	t.Run("string length", func(t *testing.T) {
+		// the length is checked before anything is allocated
+		encoded := []byte{byte(encodeTypeDocumentStart), byte(encodeTypeString)}
+		encoded = binary.LittleEndian.AppendUint64(encoded, 1<<40)
+		var doc Document
+		limitErr(t, doc.UnmarshalBinary(rawPayload(encoded...)), "MaxStringLength")
+
+		encoded = []byte{byte(encodeTypeDocumentStart), byte(encodeTypeKeyDef)}
+		encoded = binary.AppendUvarint(encoded, 1<<40)
+		limitErr(t, doc.UnmarshalBinary(rawPayload(encoded...)), "MaxStringLength")
+
+		// a truncated long string is corrupt
+		encoded = []byte{byte(encodeTypeDocumentStart), byte(encodeTypeString)}
+		encoded = binary.LittleEndian.AppendUint64(encoded, DefaultMaxStringLength)
+		encoded = append(encoded, bytes.Repeat([]byte{'x'}, 3*maxPrealloc)...)
+		err := doc.UnmarshalBinary(rawPayload(encoded...))
+		assert(t, errors.Is(err, ErrCorrupt), "expected ErrCorrupt got %v", err)
+
+		// while a complete one decodes
+		long := strings.Repeat("x", 3*maxPrealloc+1)
+		data, err := Document{long: long}.MarshalBinary()
+		ok(t, err)
+		ok(t, doc.UnmarshalBinary(data))
+		equals(t, Document{long: long}, doc)
+		limitErr(t, DecodeOptions{MaxStringLength: maxPrealloc}.Unmarshal(data, &doc), "MaxStringLength")
+	})
+
+	t.Run("elements and bytes", func(t *testing.T) {
+		doc := loadDeparture(t)
+		data, err := doc.MarshalBinary()
+		ok(t, err)
+		var doc2 Document
+		limitErr(t, DecodeOptions{MaxElements: 10}.Unmarshal(data, &doc2), "MaxElements")
+		limitErr(t, DecodeOptions{MaxBytes: 100}.Unmarshal(data, &doc2), "MaxBytes")
+		limitErr(t, DecodeOptions{MaxBytes: 100}.Unmarshal(legacyJSONPayload(t, doc), &doc2), "MaxBytes")
+		limitErr(t, DecodeOptions{MaxBytes: 100}.Unmarshal(legacySnappyPayload(t, doc), &doc2), "MaxBytes")
+		assert(t, doc2 == nil, "document should not be set on error")
+	})
+}
+
+func FuzzUnmarshalBinary(f *testing.F) {
+	doc := sampleDoc()
+	doc["address"] = Document{"city": "Toronto", "postal_zip": nil}
+	for _, o := range []EncodeOptions{
+		{Checksum: true, KeyTable: true},
+		{Codec: CodecNone},
+		{Codec: CodecNone, KeyTable: true},
+		{Codec: CodecNone, Dictionary: DictionaryGAPI},
+	} {
+		data, err := o.Marshal(doc)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(serBinary)))
+	w := snappy.NewBufferedWriter(buf)
+	if err := encodeDocument(w, doc, false); err != nil {
+		f.Fatal(err)
+	}
+	w.Close()
+	f.Add(buf.Bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var doc Document
+		o := DecodeOptions{MaxBytes: 1 << 20}
+		if err := o.Unmarshal(data, &doc); err != nil {
+			return
+		}
+		// anything decoded has to encode and decode again
+		out, err := doc.MarshalBinary()
+		ok(t, err)
+		var doc2 Document
+		ok(t, doc2.UnmarshalBinary(out))
+		equals(t, len(doc), len(doc2))
+	})
+}
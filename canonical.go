@@ -0,0 +1,172 @@
+package apidoc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// The canonical JSON written with JSONOptions.Canonical follows RFC 8785
+// (JSON Canonicalization Scheme), so that independent implementations write
+// the same bytes for the same Document:
+//
+//   - no whitespace
+//   - keys sorted by their UTF-16 code units
+//   - numbers formatted as ECMAScript does, json.Number values included
+//   - strings escaped minimally, with \u00xx for control characters
+//
+// Strings must be valid UTF-8, they are written as is without normalization.
+
+func jcsMarshalValue(w *bufio.Writer, val interface{}) error {
+	switch val := val.(type) {
+	case string:
+		return jcsMarshalString(w, val)
+	case float64:
+		return jcsMarshalFloat64(w, val)
+	case json.Number:
+		if val == "" {
+			val = "0"
+		}
+		if !isValidNumber(string(val)) {
+			return fmt.Errorf("invalid number %q", val)
+		}
+		n, err := strconv.ParseFloat(string(val), 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", val)
+		}
+		return jcsMarshalFloat64(w, n)
+	case bool:
+		return jsonMarshalBool(w, val)
+	case Document:
+		return jcsMarshalDocument(w, val)
+	case []interface{}:
+		return jcsMarshalList(w, val)
+	case nil:
+		return jsonMarshalNil(w)
+	default:
+		return fmt.Errorf("unexpected type %T for value %v", val, val)
+	}
+}
+
+func jcsMarshalDocument(w *bufio.Writer, doc Document) error {
+	keys := jcsSortedKeys(doc)
+	if err := w.WriteByte('{'); err != nil {
+		return err
+	}
+	for idx, key := range keys {
+		if idx > 0 {
+			if err := w.WriteByte(','); err != nil {
+				return err
+			}
+		}
+		if err := jcsMarshalString(w, key); err != nil {
+			return err
+		}
+		if err := w.WriteByte(':'); err != nil {
+			return err
+		}
+		if err := jcsMarshalValue(w, doc[key]); err != nil {
+			return fmt.Errorf("key %s: %w", key, err)
+		}
+	}
+	return w.WriteByte('}')
+}
+
+// jcsSortedKeys returns the keys of the Document sorted by their UTF-16 code
+// units, which only differs from sorting their UTF-8 bytes when a key holds
+// characters outside the Basic Multilingual Plane
+func jcsSortedKeys(doc Document) []string {
+	keys := doc.KeysSorted()
+	for _, key := range keys {
+		for _, r := range key {
+			if r >= 0x10000 {
+				sort.Slice(keys, func(i, j int) bool {
+					return jcsLess(keys[i], keys[j])
+				})
+				return keys
+			}
+		}
+	}
+	return keys
+}
+
+func jcsLess(a, b string) bool {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for k := 0; k < len(ua) && k < len(ub); k++ {
+		if ua[k] != ub[k] {
+			return ua[k] < ub[k]
+		}
+	}
+	return len(ua) < len(ub)
+}
+
+func jcsMarshalList(w *bufio.Writer, list []interface{}) error {
+	if err := w.WriteByte('['); err != nil {
+		return err
+	}
+	for idx, val := range list {
+		if idx > 0 {
+			if err := w.WriteByte(','); err != nil {
+				return err
+			}
+		}
+		if err := jcsMarshalValue(w, val); err != nil {
+			return fmt.Errorf("item at index %d: %w", idx, err)
+		}
+	}
+	return w.WriteByte(']')
+}
+
+const hexDigits = "0123456789abcdef"
+
+func jcsMarshalString(w *bufio.Writer, s string) error {
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("invalid UTF-8 in string %q", s)
+	}
+	if err := w.WriteByte(jsonQuote); err != nil {
+		return err
+	}
+	var err error
+	for i := 0; i < len(s) && err == nil; i++ {
+		c := s[i]
+		switch c {
+		case '\b':
+			_, err = w.WriteString(`\b`)
+		case '\t':
+			_, err = w.WriteString(`\t`)
+		case '\n':
+			_, err = w.WriteString(`\n`)
+		case '\f':
+			_, err = w.WriteString(`\f`)
+		case '\r':
+			_, err = w.WriteString(`\r`)
+		case jsonQuote:
+			_, err = w.WriteString(`\"`)
+		case backSlash:
+			_, err = w.WriteString(`\\`)
+		default:
+			if c < ' ' {
+				_, err = w.Write([]byte{backSlash, 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF]})
+			} else {
+				err = w.WriteByte(c)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return w.WriteByte(jsonQuote)
+}
+
+// jcsMarshalFloat64 writes the number as ECMAScript's Number.toString does,
+// which appendFloat64 follows apart from negative zero
+func jcsMarshalFloat64(w *bufio.Writer, n float64) error {
+	if n == 0 {
+		return w.WriteByte('0')
+	}
+	return jsonMarshalFloat64(w, n)
+}
@@ -0,0 +1,115 @@
+package apidoc
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+// Codec compresses the encoded Document of a binary payload. The ID of the
+// codec is recorded in the header so UnmarshalBinary can decode payloads
+// written with any registered codec.
+type Codec interface {
+	// ID identifies the codec in binary payloads, IDs below 64 are reserved
+	// for the codecs provided by this package
+	ID() uint8
+	// Name is used in errors and logs
+	Name() string
+	// NewWriter returns a writer compressing into w, it is closed once the
+	// Document has been written
+	NewWriter(w io.Writer) io.WriteCloser
+	// NewReader returns a reader decompressing from r
+	NewReader(r io.Reader) (io.Reader, error)
+}
+
+// reservedIDs are the codec and dictionary IDs kept for this package
+const reservedIDs = 64
+
+// the codecs provided by this package
+var (
+	CodecNone   Codec = noneCodec{}
+	CodecSnappy Codec = snappyCodec{}
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[uint8]Codec{
+		CodecNone.ID():   CodecNone,
+		CodecSnappy.ID(): CodecSnappy,
+	}
+)
+
+// RegisterCodec makes a codec available to UnmarshalBinary, it is usually
+// called from an init function. RegisterCodec panics if the ID is reserved
+// or a codec with the same ID is already registered.
+//
+// e.g. a gzip codec using compress/gzip from the standard library:
+//
+//	type gzipCodec struct{}
+//
+//	func (gzipCodec) ID() uint8    { return 64 }
+//	func (gzipCodec) Name() string { return "gzip" }
+//	func (gzipCodec) NewWriter(w io.Writer) io.WriteCloser {
+//		return gzip.NewWriter(w)
+//	}
+//	func (gzipCodec) NewReader(r io.Reader) (io.Reader, error) {
+//		return gzip.NewReader(r)
+//	}
+//
+//	func init() {
+//		apidoc.RegisterCodec(gzipCodec{})
+//	}
+func RegisterCodec(c Codec) {
+	if c.ID() < reservedIDs {
+		panic(fmt.Sprintf("apidoc: RegisterCodec called with reserved ID %d (%s)", c.ID(), c.Name()))
+	}
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if existing, dup := codecs[c.ID()]; dup {
+		panic(fmt.Sprintf("apidoc: RegisterCodec called twice for ID %d (%s and %s)", c.ID(), existing.Name(), c.Name()))
+	}
+	codecs[c.ID()] = c
+}
+
+func codecByID(id uint8) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[id]
+	return c, ok
+}
+
+type noneCodec struct{}
+
+func (noneCodec) ID() uint8    { return 0 }
+func (noneCodec) Name() string { return "none" }
+
+func (noneCodec) NewWriter(w io.Writer) io.WriteCloser {
+	return nopWriteCloser{w}
+}
+
+func (noneCodec) NewReader(r io.Reader) (io.Reader, error) {
+	return r, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) ID() uint8    { return 1 }
+func (snappyCodec) Name() string { return "snappy" }
+
+func (snappyCodec) NewWriter(w io.Writer) io.WriteCloser {
+	return snappy.NewBufferedWriter(w)
+}
+
+func (snappyCodec) NewReader(r io.Reader) (io.Reader, error) {
+	return snappy.NewReader(r), nil
+}
@@ -0,0 +1,83 @@
+package apidoc
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+)
+
+type gzipCodec struct{}
+
+func (gzipCodec) ID() uint8    { return 64 }
+func (gzipCodec) Name() string { return "gzip" }
+
+func (gzipCodec) NewWriter(w io.Writer) io.WriteCloser {
+	gw, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+	return gw
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+func init() {
+	RegisterCodec(gzipCodec{})
+}
+
+func TestCodecs(t *testing.T) {
+	doc := bigSampleDoc(2)
+	sizes := make(map[string]int)
+	for _, codec := range []Codec{CodecNone, CodecSnappy, gzipCodec{}} {
+		data, err := EncodeOptions{Codec: codec, Checksum: true}.Marshal(doc)
+		ok(t, err)
+		equals(t, codec.ID(), data[5])
+		sizes[codec.Name()] = len(data)
+
+		var doc2 Document
+		ok(t, doc2.UnmarshalBinary(data))
+		assert(t, doc.Equal(doc2), "%s payload should round trip", codec.Name())
+	}
+	assert(t, sizes["snappy"] < sizes["none"], "snappy should compress %v", sizes)
+	assert(t, sizes["gzip"] < sizes["snappy"], "gzip should compress better than snappy %v", sizes)
+
+	// the default codec is snappy
+	data, err := EncodeOptions{}.Marshal(doc)
+	ok(t, err)
+	equals(t, CodecSnappy.ID(), data[5])
+}
+
+func TestCodecCorrupt(t *testing.T) {
+	data, err := EncodeOptions{Codec: gzipCodec{}}.Marshal(sampleDoc())
+	ok(t, err)
+	data[binaryHeaderSize] ^= 0xFF
+	var doc Document
+	err = doc.UnmarshalBinary(data)
+	assert(t, errors.Is(err, ErrCorrupt), "expected ErrCorrupt got %v", err)
+}
+
+// idCodec is gzipCodec registered under another ID
+type idCodec struct {
+	gzipCodec
+	id uint8
+}
+
+func (c idCodec) ID() uint8 { return c.id }
+
+func TestRegisterCodecInvalid(t *testing.T) {
+	for name, codec := range map[string]Codec{
+		"duplicate ID": gzipCodec{},
+		"none ID":      idCodec{id: CodecNone.ID()},
+		"snappy ID":    idCodec{id: CodecSnappy.ID()},
+		"reserved ID":  idCodec{id: 63},
+	} {
+		func() {
+			defer func() {
+				assert(t, recover() != nil, "%s: expected RegisterCodec to panic", name)
+			}()
+			RegisterCodec(codec)
+		}()
+	}
+	c, found := codecByID(CodecNone.ID())
+	assert(t, found && c == CodecNone, "CodecNone should stay registered")
+}
@@ -0,0 +1,101 @@
+package apidoc
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Dictionary is a shared table of keys known to both the writer and reader of
+// a binary payload, so that they are referred to by index without being
+// defined in the payload. The ID of the dictionary is recorded in the header.
+//
+// A Dictionary must never change once payloads have been written with it,
+// instead a new version is registered with a new ID and the old one is kept
+// for as long as payloads written with it may be read.
+type Dictionary struct {
+	// ID identifies the dictionary in binary payloads, IDs below 64 are
+	// reserved for the dictionaries provided by this package
+	ID uint32
+	// Keys are the keys of the dictionary, referred to by their index
+	Keys []string
+
+	idx map[string]uint64
+}
+
+// DictionaryGAPI holds keys common to G API resources
+var DictionaryGAPI = &Dictionary{
+	ID: 1,
+	Keys: []string{
+		"id", "href", "name", "type", "sub_type", "code", "currency",
+		"amount", "start_date", "finish_date", "min_days", "max_days",
+		"flags", "deposit", "promotions", "message", "product", "details",
+		"description", "summary", "status", "total", "availability",
+		"date_created", "date_last_modified", "request_space_date",
+		"halt_booking_date", "street", "city", "country", "postal_zip",
+		"latitude", "longitude", "rooms", "prices", "price_bands",
+		"addons", "add_ons", "detail_type", "product_line", "sku",
+		"start_address", "finish_address", "min_age", "max_age",
+		"min_travellers", "max_travellers",
+	},
+}
+
+var (
+	dictionariesMu sync.RWMutex
+	dictionaries   = map[uint32]*Dictionary{}
+)
+
+func init() {
+	registerDictionary(DictionaryGAPI)
+}
+
+// RegisterDictionary makes a dictionary available to EncodeOptions and
+// UnmarshalBinary, it is usually called from an init function.
+// RegisterDictionary panics if the ID is reserved or already registered, or
+// if the dictionary holds the same key twice.
+func RegisterDictionary(dict *Dictionary) {
+	if dict.ID < reservedIDs {
+		panic(fmt.Sprintf("apidoc: RegisterDictionary called with reserved ID %d", dict.ID))
+	}
+	registerDictionary(dict)
+}
+
+func registerDictionary(dict *Dictionary) {
+	idx := make(map[string]uint64, len(dict.Keys))
+	for i, key := range dict.Keys {
+		if _, dup := idx[key]; dup {
+			panic(fmt.Sprintf("apidoc: dictionary %d holds key %q twice", dict.ID, key))
+		}
+		idx[key] = uint64(i)
+	}
+
+	dictionariesMu.Lock()
+	defer dictionariesMu.Unlock()
+	if _, dup := dictionaries[dict.ID]; dup {
+		panic(fmt.Sprintf("apidoc: RegisterDictionary called twice for ID %d", dict.ID))
+	}
+	dict.idx = idx
+	dictionaries[dict.ID] = dict
+}
+
+func dictionaryByID(id uint32) (*Dictionary, bool) {
+	dictionariesMu.RLock()
+	defer dictionariesMu.RUnlock()
+	dict, ok := dictionaries[id]
+	return dict, ok
+}
+
+// index returns the index of key, a nil Dictionary holds no keys
+func (dict *Dictionary) index(key string) (uint64, bool) {
+	if dict == nil {
+		return 0, false
+	}
+	idx, ok := dict.idx[key]
+	return idx, ok
+}
+
+func (dict *Dictionary) len() int {
+	if dict == nil {
+		return 0
+	}
+	return len(dict.Keys)
+}
@@ -0,0 +1,97 @@
+package apidoc
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+func TestKeyTable(t *testing.T) {
+	doc := loadDeparture(t)
+	plain, err := EncodeOptions{Codec: CodecNone}.Marshal(doc)
+	ok(t, err)
+	keyed, err := EncodeOptions{Codec: CodecNone, KeyTable: true}.Marshal(doc)
+	ok(t, err)
+	assert(t, len(keyed) < len(plain), "key table should be smaller: %d >= %d", len(keyed), len(plain))
+	equals(t, 16, bytes.Count(plain, []byte("currency")))
+	equals(t, 1, bytes.Count(keyed, []byte("currency")))
+
+	var doc2 Document
+	ok(t, doc2.UnmarshalBinary(keyed))
+	assert(t, doc.Equal(doc2), "departure should round trip")
+
+	// a reference to a key that was never defined
+	bad := append([]byte{}, keyed[:binaryHeaderSize]...)
+	bad = append(bad, byte(encodeTypeDocumentStart), byte(encodeTypeKeyRef), 3, byte(encodeTypeNil), byte(encodeTypeDocumentEnd))
+	err = doc2.UnmarshalBinary(bad)
+	assert(t, errors.Is(err, ErrCorrupt), "expected ErrCorrupt got %v", err)
+}
+
+func TestDictionary(t *testing.T) {
+	doc := loadDeparture(t)
+	keyed, err := EncodeOptions{Codec: CodecNone, KeyTable: true}.Marshal(doc)
+	ok(t, err)
+	data, err := EncodeOptions{Codec: CodecNone, Dictionary: DictionaryGAPI}.Marshal(doc)
+	ok(t, err)
+	equals(t, byte(binaryFlagKeyTable|binaryFlagDictionary), data[6])
+	equals(t, byte(DictionaryGAPI.ID), data[binaryHeaderSize])
+	assert(t, len(data) < len(keyed), "dictionary should be smaller: %d >= %d", len(data), len(keyed))
+	equals(t, 0, bytes.Count(data, []byte("currency")))
+
+	var doc2 Document
+	ok(t, doc2.UnmarshalBinary(data))
+	assert(t, doc.Equal(doc2), "departure should round trip")
+
+	unknown := append([]byte{}, data...)
+	unknown[binaryHeaderSize] = 63
+	err = doc2.UnmarshalBinary(unknown)
+	assert(t, errors.Is(err, ErrUnknownDictionary), "expected ErrUnknownDictionary got %v", err)
+
+	// dictionaries have to be registered to be decoded
+	_, err = EncodeOptions{Dictionary: &Dictionary{ID: 63, Keys: []string{"id"}}}.Marshal(doc)
+	assert(t, errors.Is(err, ErrUnknownDictionary), "expected ErrUnknownDictionary got %v", err)
+}
+
+func TestRegisterDictionaryInvalid(t *testing.T) {
+	RegisterDictionary(&Dictionary{ID: 64, Keys: []string{"id"}})
+	for name, dict := range map[string]*Dictionary{
+		"duplicate ID":  {ID: 64},
+		"zero ID":       {},
+		"reserved ID":   {ID: 63},
+		"builtin ID":    {ID: DictionaryGAPI.ID},
+		"duplicate key": {ID: 65, Keys: []string{"id", "id"}},
+	} {
+		func() {
+			defer func() {
+				assert(t, recover() != nil, "%s: expected RegisterDictionary to panic", name)
+			}()
+			RegisterDictionary(dict)
+		}()
+	}
+}
+
+func TestDecodeInternsStrings(t *testing.T) {
+	doc := loadDeparture(t)
+	data, err := doc.MarshalBinary()
+	ok(t, err)
+	var doc2 Document
+	ok(t, doc2.UnmarshalBinary(data))
+
+	// each currency is in both lowest_pp2a_prices and the room prices
+	seen := make(map[string]uintptr)
+	matches := append(
+		doc2.GetPathAll("lowest_pp2a_prices", "*", "currency"),
+		doc2.GetPathAll("rooms", "0", "price_bands", "0", "prices", "*", "currency")...)
+	equals(t, 16, len(matches))
+	for _, m := range matches {
+		s := m.Value.(string)
+		data := (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+		if prev, found := seen[s]; found {
+			assert(t, prev == data, "%s at %s should be interned", s, m.Path)
+		}
+		seen[s] = data
+	}
+	equals(t, 8, len(seen))
+}
@@ -0,0 +1,154 @@
+package apidoc
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ChangeType is the kind of a Change reported by Diff
+type ChangeType int
+
+// the kinds of changes reported by Diff
+const (
+	ChangeAdded ChangeType = iota + 1
+	ChangeRemoved
+	ChangeModified
+)
+
+// String representation of ChangeType
+func (c ChangeType) String() string {
+	switch c {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "changed"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(c))
+	}
+}
+
+// Change is a single difference between two Documents. Old is not set for
+// added values and New is not set for removed values.
+type Change struct {
+	Type ChangeType
+	Path Path
+	Old  interface{}
+	New  interface{}
+}
+
+// String renders the change in a human readable form, e.g.
+//
+//	rooms[0].availability.total: 5 -> 3
+//	rooms[0].flags[0]: added "FULL"
+//	start_address.postal_zip: removed null
+func (c Change) String() string {
+	switch c.Type {
+	case ChangeAdded:
+		return fmt.Sprintf("%s: added %s", c.Path, formatValue(c.New))
+	case ChangeRemoved:
+		return fmt.Sprintf("%s: removed %s", c.Path, formatValue(c.Old))
+	default:
+		return fmt.Sprintf("%s: %s -> %s", c.Path, formatValue(c.Old), formatValue(c.New))
+	}
+}
+
+// FormatDiff renders the changes one per line
+func FormatDiff(changes []Change) string {
+	var b strings.Builder
+	for _, c := range changes {
+		b.WriteString(c.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Diff returns the changes required to turn Document a into Document b.
+// Documents are compared key by key in sorted order and lists index by
+// index, so an item inserted at the front of a list is reported as a change
+// to every following index plus an addition at the end. Values of different
+// types are reported as changed.
+//
+// Items removed from the end of a list are reported from the highest index
+// down, so that the changes can be applied in order.
+func Diff(a, b Document) []Change {
+	var changes []Change
+	diffDocuments(nil, a, b, func(c Change) {
+		changes = append(changes, c)
+	})
+	return changes
+}
+
+func diffDocuments(path Path, a, b Document, emit func(Change)) {
+	keys := a.KeysSorted()
+	for _, k := range b.KeysSorted() {
+		if _, prs := a[k]; !prs {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		av, inA := a[k]
+		bv, inB := b[k]
+		switch {
+		case !inB:
+			emit(Change{Type: ChangeRemoved, Path: appendPath(path, k), Old: av})
+		case !inA:
+			emit(Change{Type: ChangeAdded, Path: appendPath(path, k), New: bv})
+		default:
+			diffValues(appendPath(path, k), av, bv, emit)
+		}
+	}
+}
+
+func diffLists(path Path, a, b []interface{}, emit func(Change)) {
+	common := len(a)
+	if len(b) < common {
+		common = len(b)
+	}
+	for i := 0; i < common; i++ {
+		diffValues(appendPath(path, strconv.Itoa(i)), a[i], b[i], emit)
+	}
+	for i := common; i < len(b); i++ {
+		emit(Change{Type: ChangeAdded, Path: appendPath(path, strconv.Itoa(i)), New: b[i]})
+	}
+	for i := len(a) - 1; i >= common; i-- {
+		emit(Change{Type: ChangeRemoved, Path: appendPath(path, strconv.Itoa(i)), Old: a[i]})
+	}
+}
+
+func diffValues(path Path, a, b interface{}, emit func(Change)) {
+	switch at := a.(type) {
+	case Document:
+		if bt, ok := b.(Document); ok {
+			diffDocuments(path, at, bt, emit)
+			return
+		}
+	case []interface{}:
+		if bt, ok := b.([]interface{}); ok {
+			diffLists(path, at, bt, emit)
+			return
+		}
+	}
+	if !equalValues(a, b) {
+		emit(Change{Type: ChangeModified, Path: path, Old: a, New: b})
+	}
+}
+
+// formatValue renders v as compact JSON, falling back to %v for values
+// that cannot be marshaled
+func formatValue(v interface{}) string {
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	if err := jsonMarshalValue(w, v); err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return b.String()
+}
@@ -0,0 +1,71 @@
+package apidoc
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := loadDeparture(t)
+	b := *a.Copy()
+	equals(t, 0, len(Diff(a, b)))
+
+	ok(t, b.SetPath(3.0, "rooms", "0", "availability", "total"))
+	ok(t, b.SetPath("FULL", "rooms", "0", "flags", "0"))
+	ok(t, b.DeletePath("start_address", "postal_zip"))
+	ok(t, b.DeletePath("lowest_pp2a_prices", "-1"))
+	ok(t, b.DeletePath("lowest_pp2a_prices", "-1"))
+	ok(t, b.SetPath(Document{"id": "1"}, "promotion"))
+	ok(t, b.SetPath(5.0, "tour", "id"))
+
+	changes := Diff(a, b)
+	equals(t, []Change{
+		{Type: ChangeRemoved, Path: Path{"lowest_pp2a_prices", "7"}, Old: Document{"currency": "EUR", "amount": "929.00"}},
+		{Type: ChangeRemoved, Path: Path{"lowest_pp2a_prices", "6"}, Old: Document{"currency": "ZAR", "amount": "15939.00"}},
+		{Type: ChangeAdded, Path: Path{"promotion"}, New: Document{"id": "1"}},
+		{Type: ChangeModified, Path: Path{"rooms", "0", "availability", "total"}, Old: 5.0, New: 3.0},
+		{Type: ChangeAdded, Path: Path{"rooms", "0", "flags", "0"}, New: "FULL"},
+		{Type: ChangeRemoved, Path: Path{"start_address", "postal_zip"}},
+		{Type: ChangeModified, Path: Path{"tour", "id"}, Old: "23185", New: 5.0},
+	}, changes)
+
+	equals(t, `lowest_pp2a_prices[7]: removed {"amount":"929.00","currency":"EUR"}
+lowest_pp2a_prices[6]: removed {"amount":"15939.00","currency":"ZAR"}
+promotion: added {"id":"1"}
+rooms[0].availability.total: 5 -> 3
+rooms[0].flags[0]: added "FULL"
+start_address.postal_zip: removed null
+tour.id: "23185" -> 5
+`, FormatDiff(changes))
+
+	// the reverse diff swaps additions and removals
+	for _, c := range Diff(b, a) {
+		switch c.Path.String() {
+		case "promotion", "start_address.postal_zip", "rooms[0].flags[0]":
+			assert(t, c.Type != ChangeModified, "%s should be added or removed", c)
+		}
+	}
+}
+
+func TestDiffTypeChanges(t *testing.T) {
+	a := Document{"x": Document{"a": 1.0}, "y": []interface{}{1.0}, "z": nil}
+	b := Document{"x": []interface{}{1.0}, "y": Document{"a": 1.0}, "z": false}
+	changes := Diff(a, b)
+	equals(t, 3, len(changes))
+	for _, c := range changes {
+		equals(t, ChangeModified, c.Type)
+	}
+	equals(t, `z: null -> false`, changes[2].String())
+	equals(t, "changed", ChangeModified.String())
+	equals(t, "Unknown(0)", ChangeType(0).String())
+}
+
+func TestDiffNumbers(t *testing.T) {
+	var a Document
+	ok(t, JSONOptions{UseNumber: true}.Unmarshal([]byte(`{"a":5,"b":[1.50],"c":1}`), &a))
+	b := Document{"a": 5.0, "b": []interface{}{1.5}, "c": json.Number("2")}
+	changes := Diff(a, b)
+	equals(t, 1, len(changes))
+	equals(t, `c: 1 -> 2`, changes[0].String())
+	equals(t, 0, len(CreateMergePatch(a, Document{"a": 5.0, "b": []interface{}{1.5}, "c": 1.0})))
+}
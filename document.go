@@ -1,16 +1,10 @@
 package apidoc
 
 import (
-	"bytes"
-	"encoding/binary"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"hash/crc32"
 	"reflect"
 	"sort"
-
-	"github.com/golang/snappy"
 )
 
 // Document represents a single G API resource. Internally its contents
@@ -18,6 +12,7 @@ import (
 //
 // bool          for booleans
 // float64       for numbers
+// json.Number   for numbers, when decoded with JSONOptions.UseNumber
 // string        for strings
 // []interface{} for arrays
 // Document      for nested objects
@@ -55,7 +50,9 @@ func (d Document) String() string {
 	return string(data)
 }
 
-// Equal compares if two Documents are the same
+// Equal compares if two Documents are the same. Numbers must have the same
+// representation to be equal, float64(5) is not equal to json.Number("5")
+// and json.Number("5.0") is not equal to json.Number("5").
 func (d Document) Equal(other Document) bool {
 	return reflect.DeepEqual(d, other)
 }
@@ -98,91 +95,6 @@ func (d Document) TraverseCall(f TraverseFunc) {
 	}
 }
 
-type serializationType uint32
-
-const (
-	serInvalid serializationType = iota
-	serBinary                    // this is the snappy encoded version
-)
-
-// UnmarshalBinary implements binary decoding
-func (d *Document) UnmarshalBinary(data []byte) error {
-	var oldcrc uint32
-	buf := bytes.NewBuffer(data)
-	err := binary.Read(buf, binary.LittleEndian, &oldcrc)
-	if err != nil {
-		return fmt.Errorf("binary.Read of checksum failed: unmarshaling: %w", err)
-	}
-	remdata := buf.Bytes()
-	switch serializationType(oldcrc) {
-	case serBinary:
-		val, err := decodeValue(snappy.NewReader(bytes.NewReader(remdata)))
-		if err != nil {
-			return err
-		}
-		doc, ok := val.(Document)
-		if !ok {
-			return fmt.Errorf("expected Document got %T", val)
-		}
-		*d = doc
-		return nil
-	default:
-		// must be legacy json then
-	}
-	if crc32.ChecksumIEEE(remdata) != oldcrc {
-		return errors.New("checksum does not match - unmarshaling")
-	}
-	return json.Unmarshal(remdata, d)
-}
-
-// MarshalBinary allows documents to be stored in cache
-func (d Document) MarshalBinary() ([]byte, error) {
-	buf := bytes.NewBuffer(make([]byte, 0, 512*len(d)))
-	if err := binary.Write(
-		buf, binary.LittleEndian, uint32(serBinary)); err != nil {
-		return nil, errors.New("failed to encode serializationType - marshaling")
-	}
-	w := snappy.NewBufferedWriter(buf)
-	err := encodeDocument(w, d, false)
-	if err != nil {
-		return nil, err
-	}
-	err = w.Close()
-	return buf.Bytes(), err
-}
-
-// ErrGAPI type represents error response returned by GAPI
-//
-// See: https://developers.gadventures.com/docs/rest.html#errors
-type ErrGAPI struct {
-	HTTPStatusCode int
-	Message        string
-	ErrorID        string
-	URI            string
-}
-
-// Error satisfies the error interface for the ErrGAPI type
-func (r *ErrGAPI) Error() string {
-	return fmt.Sprintf("GAPI error for %s HTTP Status %d %s %s", r.URI, r.HTTPStatusCode, r.ErrorID, r.Message)
-}
-
-// GAPIError returns *ErrGAPI if the document is a GAPI error, nil otherwise
-func (d Document) GAPIError(uri string) *ErrGAPI {
-	_, hasError := d["error_id"]
-	if !hasError {
-		return nil
-	}
-	errID, _ := d["error_id"].(string)
-	msg, _ := d["message"].(string)
-	status, _ := d["http_status_code"].(int)
-	return &ErrGAPI{
-		ErrorID:        errID,
-		HTTPStatusCode: status,
-		Message:        msg,
-		URI:            uri,
-	}
-}
-
 // GetPath recursively searches for value at provided path
 //
 // e.g. GetPath("staff_profile", "id") would return "value" under the
@@ -194,21 +106,14 @@ func (d Document) GAPIError(uri string) *ErrGAPI {
 //	    "id": "value"
 //	  }
 //	}
+//
+// Segments applied to a list are indexes, negative indexes count back from
+// the end of the list, e.g. GetPath("rooms", "0", "price_bands", "-1").
+// A PathWildcard segment returns the first match, use GetPathAll to get
+// every match.
 func (d Document) GetPath(parts ...string) (interface{}, bool) {
-	switch len(parts) {
-	case 0:
+	if len(parts) == 0 {
 		return "", false
-	case 1:
-		v, prs := d[parts[0]]
-		return v, prs
-	default:
-		// following a nested path
-		//
-		// NOTE: we are not dealing with lists at this time
-		doc, ok := d[parts[0]].(Document)
-		if !ok {
-			return nil, false
-		}
-		return doc.GetPath(parts[1:]...)
 	}
+	return getPath(d, parts)
 }
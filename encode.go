@@ -2,8 +2,10 @@ package apidoc
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 )
 
 type encodeType uint8
@@ -18,6 +20,12 @@ const (
 	encodeTypeDocumentStart
 	encodeTypeDocumentEnd
 	encodeTypeNil
+	encodeTypeNumber
+	encodeTypeInt
+	encodeTypeKeyDef
+	encodeTypeKeyRef
+	encodeTypeIndexedDocument
+	encodeTypeIndexedList
 )
 
 // byteValue return the byte value of the encodeType
@@ -46,6 +54,18 @@ func (e encodeType) String() string {
 		return "DocumentEnd"
 	case encodeTypeNil:
 		return "Nil"
+	case encodeTypeNumber:
+		return "Number"
+	case encodeTypeInt:
+		return "Int"
+	case encodeTypeKeyDef:
+		return "KeyDef"
+	case encodeTypeKeyRef:
+		return "KeyRef"
+	case encodeTypeIndexedDocument:
+		return "IndexedDocument"
+	case encodeTypeIndexedList:
+		return "IndexedList"
 	default:
 		return fmt.Sprintf("Unknown(%d)", e)
 	}
@@ -70,21 +90,42 @@ func encodeBool(w io.Writer, b bool) error {
 	return err
 }
 
+// encoder writes the binary encoding of Documents
+type encoder struct {
+	w io.Writer
+	// sortKeys writes Document keys in sorted order, giving the stable
+	// encoding used to calculate ETags
+	sortKeys bool
+	// compactInts writes integral numbers as varints instead of float64,
+	// it is not used for ETags so that they are unchanged by it
+	compactInts bool
+	// keys is the key table, when set each key is written once and then
+	// referred to by its index, after those of dict
+	keys map[string]uint64
+	dict *Dictionary
+}
+
 func encodeDocument(w io.Writer, doc Document, sortKeys bool) error {
+	e := encoder{w: w, sortKeys: sortKeys}
+	return e.encodeDocument(doc)
+}
+
+func (e *encoder) encodeDocument(doc Document) error {
+	w := e.w
 	if err := encodeEncodeType(w, encodeTypeDocumentStart); err != nil {
 		return err
 	}
 
 	// extract the Document keys and sort them if necessary
 	var keys []string
-	if sortKeys {
+	if e.sortKeys {
 		keys = doc.KeysSorted()
 	} else {
 		keys = doc.Keys()
 	}
 
 	for _, key := range keys {
-		err := encodeString(w, key)
+		err := e.encodeKey(key)
 		if err != nil {
 			return err
 		}
@@ -97,13 +138,15 @@ func encodeDocument(w io.Writer, doc Document, sortKeys bool) error {
 			case string:
 				err = encodeString(w, val)
 			case float64:
-				err = encodeFloat64(w, val)
+				err = e.encodeFloat64(val)
+			case json.Number:
+				err = encodeNumber(w, val)
 			case bool:
 				err = encodeBool(w, val)
 			case Document:
-				err = encodeDocument(w, val, sortKeys)
+				err = e.encodeDocument(val)
 			case []interface{}:
-				err = encodeList(w, val, sortKeys)
+				err = e.encodeList(val)
 			default:
 				return fmt.Errorf(
 					"key %s has unexpected type %T for value %v",
@@ -118,6 +161,48 @@ func encodeDocument(w io.Writer, doc Document, sortKeys bool) error {
 	return encodeEncodeType(w, encodeTypeDocumentEnd)
 }
 
+// encodeKey writes a Document key, either as a string or using the key table
+func (e *encoder) encodeKey(key string) error {
+	if e.keys == nil {
+		return encodeString(e.w, key)
+	}
+
+	var buf [1 + binary.MaxVarintLen64]byte
+	idx, ok := e.dict.index(key)
+	if !ok {
+		idx, ok = e.keys[key]
+		idx += uint64(e.dict.len())
+	}
+	if ok {
+		buf[0] = byte(encodeTypeKeyRef)
+		l := binary.PutUvarint(buf[1:], idx)
+		_, err := e.w.Write(buf[:1+l])
+		return err
+	}
+
+	// not seen before, define it with a varint length
+	e.keys[key] = uint64(len(e.keys))
+	buf[0] = byte(encodeTypeKeyDef)
+	l := binary.PutUvarint(buf[1:], uint64(len(key)))
+	if _, err := e.w.Write(buf[:1+l]); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, key)
+	return err
+}
+
+// maxSafeInteger is the largest integer below which every integer can be
+// exactly represented by a float64
+const maxSafeInteger = 1<<53 - 1
+
+func (e *encoder) encodeFloat64(num float64) error {
+	if e.compactInts && num == math.Trunc(num) && math.Abs(num) <= maxSafeInteger &&
+		!(num == 0 && math.Signbit(num)) {
+		return encodeInt(e.w, int64(num))
+	}
+	return encodeFloat64(e.w, num)
+}
+
 func encodeFloat64(w io.Writer, num float64) error {
 	if err := encodeEncodeType(w, encodeTypeFloat64); err != nil {
 		return err
@@ -125,29 +210,40 @@ func encodeFloat64(w io.Writer, num float64) error {
 	return binary.Write(w, binary.LittleEndian, num)
 }
 
-func encodeList(w io.Writer, list []interface{}, sortKeys bool) error {
+// encodeInt writes a zig-zag varint, which takes a single byte for numbers
+// between -64 and 63
+func encodeInt(w io.Writer, num int64) error {
+	var buf [1 + binary.MaxVarintLen64]byte
+	buf[0] = byte(encodeTypeInt)
+	l := binary.PutVarint(buf[1:], num)
+	_, err := w.Write(buf[:1+l])
+	return err
+}
+
+func (e *encoder) encodeList(list []interface{}) error {
+	w := e.w
 	if err := encodeEncodeType(w, encodeTypeListStart); err != nil {
 		return err
 	}
 
 	var err error
 	for idx, val := range list {
-		// special case nil
-		if val == nil {
-			return encodeNil(w)
-		}
 		// handled types
 		switch val := val.(type) {
+		case nil:
+			err = encodeNil(w)
 		case bool:
 			err = encodeBool(w, val)
 		case float64:
-			err = encodeFloat64(w, val)
+			err = e.encodeFloat64(val)
+		case json.Number:
+			err = encodeNumber(w, val)
 		case string:
 			err = encodeString(w, val)
 		case Document:
-			err = encodeDocument(w, val, sortKeys)
+			err = e.encodeDocument(val)
 		case []interface{}:
-			err = encodeList(w, val, sortKeys)
+			err = e.encodeList(val)
 		default:
 			return fmt.Errorf(
 				"item at index %d has unexpected type %T for value %v",
@@ -165,11 +261,22 @@ func encodeNil(w io.Writer) error {
 	return encodeEncodeType(w, encodeTypeNil)
 }
 
+// encodeNumber stores the text of a json.Number, in the same way as strings
+func encodeNumber(w io.Writer, n json.Number) error {
+	if err := encodeEncodeType(w, encodeTypeNumber); err != nil {
+		return err
+	}
+	return encodeStringData(w, string(n))
+}
+
 func encodeString(w io.Writer, s string) error {
 	if err := encodeEncodeType(w, encodeTypeString); err != nil {
 		return err
 	}
+	return encodeStringData(w, s)
+}
 
+func encodeStringData(w io.Writer, s string) error {
 	data := []byte(s)
 	if err := binary.Write(w, binary.LittleEndian, int64(len(data))); err != nil {
 		return err
@@ -180,37 +287,102 @@ func encodeString(w io.Writer, s string) error {
 
 // decode here
 
-func decodeEncodeType(r io.Reader) (encodeType, error) {
-	buf := make([]byte, 1)
-	l, err := r.Read(buf)
-	if l != len(buf) {
-		if err != nil {
-			return encodeTypeInvalid, err
-		}
-		// NOTE: len of bytes just recursively calls ourself
-		//       should be safe tail recursion
-		return decodeEncodeType(r)
+// maxInternLength is the longest string value interned by the decoder, and
+// maxInterned the most values it will hold on to
+const (
+	maxInternLength = 64
+	maxInterned     = 4096
+)
+
+// maxPrealloc is the most allocated for a string before its data is read,
+// longer strings grow as they are read so that a corrupt length cannot
+// allocate more than the payload holds
+const maxPrealloc = 64 << 10
+
+// decoder reads the binary encoding of Documents
+type decoder struct {
+	r *limitReader
+	// limits are the DecodeOptions with their defaults applied
+	limits   DecodeOptions
+	depth    int
+	elements int
+	// dict seeds the key table with a shared Dictionary
+	dict *Dictionary
+	// keys holds the keys defined by the payload, after those of dict
+	keys []string
+	// interned holds short string values already decoded, so that repeated
+	// values such as currencies share a single allocation
+	interned map[string]string
+	buf      [maxInternLength]byte
+}
+
+func newDecoder(r io.Reader, dict *Dictionary, o DecodeOptions) *decoder {
+	o = o.withDefaults()
+	return &decoder{
+		r:      &limitReader{r: r, br: asByteReader(r), max: o.MaxBytes},
+		limits: o,
+		dict:   dict,
+	}
+}
+
+func decodeValue(r io.Reader) (interface{}, error) {
+	return newDecoder(r, nil, DecodeOptions{}).decodeValue()
+}
+
+func (d *decoder) limitError(limit string, max int64) error {
+	return &LimitError{Limit: limit, Max: max, Offset: d.r.n}
+}
+
+// enter is called when starting a Document or list, and leave at its end
+func (d *decoder) enter() error {
+	d.depth++
+	if d.depth > d.limits.MaxDepth {
+		return d.limitError("MaxDepth", int64(d.limits.MaxDepth))
+	}
+	return nil
+}
+
+func (d *decoder) leave() {
+	d.depth--
+}
+
+// element is called for each value of a Document or list
+func (d *decoder) element() error {
+	d.elements++
+	if d.elements > d.limits.MaxElements {
+		return d.limitError("MaxElements", int64(d.limits.MaxElements))
 	}
-	return encodeType(buf[0]), nil
+	return nil
 }
 
-func nextItem(r io.Reader) (encodeType, interface{}, error) {
+func (d *decoder) nextItem() (encodeType, interface{}, error) {
 	var val interface{}
 
-	typ, err := decodeEncodeType(r)
+	b, err := d.r.ReadByte()
 	if err != nil {
-		return typ, nil, err
+		return encodeTypeInvalid, nil, err
 	}
 
+	typ := encodeType(b)
 	switch typ {
 	case encodeTypeDocumentStart:
 	case encodeTypeDocumentEnd:
 	case encodeTypeString:
-		val, err = decodeString(r)
+		val, err = d.decodeString()
 	case encodeTypeBool:
-		val, err = decodeBool(r)
+		val, err = decodeBool(d.r)
 	case encodeTypeFloat64:
-		val, err = decodeFloat64(r)
+		val, err = decodeFloat64(d.r)
+	case encodeTypeNumber:
+		var s string
+		s, err = d.decodeString()
+		val = json.Number(s)
+	case encodeTypeInt:
+		val, err = decodeInt(d.r)
+	case encodeTypeKeyDef:
+		val, err = d.decodeKeyDef()
+	case encodeTypeKeyRef:
+		val, err = d.decodeKeyRef()
 	case encodeTypeListStart:
 	case encodeTypeListEnd:
 	case encodeTypeNil:
@@ -221,20 +393,28 @@ func nextItem(r io.Reader) (encodeType, interface{}, error) {
 	return typ, val, err
 }
 
-func decodeDocument(r io.Reader) (Document, error) {
+func (d *decoder) decodeDocument() (Document, error) {
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+
 	doc := make(Document)
 Loop:
 	for {
-		typ, val, err := nextItem(r)
+		typ, val, err := d.nextItem()
 		if err != nil {
 			return doc, err
 		}
 		switch typ {
 		case encodeTypeDocumentEnd:
 			break Loop
-		case encodeTypeString:
+		case encodeTypeString, encodeTypeKeyDef, encodeTypeKeyRef:
+			if err := d.element(); err != nil {
+				return doc, err
+			}
 			key := val.(string)
-			value, err := decodeValue(r)
+			value, err := d.decodeValue()
 			if err != nil {
 				return doc, err
 			}
@@ -248,19 +428,21 @@ Loop:
 	return doc, nil
 }
 
-func decodeValue(r io.Reader) (interface{}, error) {
-	typ, val, err := nextItem(r)
+func (d *decoder) decodeValue() (interface{}, error) {
+	typ, val, err := d.nextItem()
 	if err != nil {
 		return nil, err
 	}
 	switch typ {
 	case encodeTypeDocumentStart:
-		val, err = decodeDocument(r)
+		val, err = d.decodeDocument()
 	case encodeTypeListStart:
-		val, err = decodeList(r)
+		val, err = d.decodeList()
 	case encodeTypeString:
 	case encodeTypeBool:
 	case encodeTypeFloat64:
+	case encodeTypeNumber:
+	case encodeTypeInt:
 	case encodeTypeNil:
 		return nil, nil
 	default:
@@ -271,24 +453,147 @@ func decodeValue(r io.Reader) (interface{}, error) {
 	return val, err
 }
 
-func decodeString(r io.Reader) (string, error) {
-	var length, bytesRead int64
-	err := binary.Read(r, binary.LittleEndian, &length)
+func (d *decoder) decodeString() (string, error) {
+	var length int64
+	err := binary.Read(d.r, binary.LittleEndian, &length)
 	if err != nil {
 		return "", err
 	}
-	raw := make([]byte, length)
-	for bytesRead < length {
-		data := raw[bytesRead:]
-		l, err := r.Read(data)
-		bytesRead += int64(l)
-		if l < 1 && err != nil {
+	return d.readString(length, true)
+}
+
+// readString reads a string of length bytes, short strings are interned
+// when intern is set
+func (d *decoder) readString(length int64, intern bool) (string, error) {
+	if length < 0 {
+		return "", fmt.Errorf("invalid string length %d", length)
+	}
+	if length > int64(d.limits.MaxStringLength) {
+		return "", d.limitError("MaxStringLength", int64(d.limits.MaxStringLength))
+	}
+	if !intern || length > maxInternLength {
+		return d.readLongString(int(length))
+	}
+
+	raw := d.buf[:length]
+	if _, err := io.ReadFull(d.r, raw); err != nil {
+		return "", err
+	}
+	// the conversion in the map index does not allocate
+	if s, ok := d.interned[string(raw)]; ok {
+		return s, nil
+	}
+	s := string(raw)
+	if d.interned == nil {
+		d.interned = make(map[string]string)
+	}
+	if len(d.interned) < maxInterned {
+		d.interned[s] = s
+	}
+	return s, nil
+}
+
+// readLongString reads a string that is not interned, growing its buffer as
+// the data is read
+func (d *decoder) readLongString(length int) (string, error) {
+	if length <= maxPrealloc {
+		raw := make([]byte, length)
+		if _, err := io.ReadFull(d.r, raw); err != nil {
 			return "", err
 		}
+		return string(raw), nil
+	}
+
+	raw := make([]byte, 0, maxPrealloc)
+	for len(raw) < length {
+		if len(raw) == cap(raw) {
+			// let append pick the new capacity
+			raw = append(raw, 0)[:len(raw)]
+		}
+		end := cap(raw)
+		if end > length {
+			end = length
+		}
+		if _, err := io.ReadFull(d.r, raw[len(raw):end]); err != nil {
+			return "", err
+		}
+		raw = raw[:end]
 	}
 	return string(raw), nil
 }
 
+// decodeKeyDef reads a key and adds it to the key table
+func (d *decoder) decodeKeyDef() (string, error) {
+	length, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return "", err
+	}
+	if length > uint64(d.limits.MaxStringLength) {
+		return "", d.limitError("MaxStringLength", int64(d.limits.MaxStringLength))
+	}
+	// keys are already held by the table, so are not interned again
+	key, err := d.readString(int64(length), false)
+	if err != nil {
+		return "", err
+	}
+	d.keys = append(d.keys, key)
+	return key, nil
+}
+
+// decodeKeyRef reads the index of a key previously added to the key table
+func (d *decoder) decodeKeyRef() (string, error) {
+	idx, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return "", err
+	}
+	n := uint64(d.dict.len())
+	if idx < n {
+		return d.dict.Keys[idx], nil
+	}
+	idx -= n
+	if idx >= uint64(len(d.keys)) {
+		return "", fmt.Errorf("key reference %d is not defined", idx)
+	}
+	return d.keys[idx], nil
+}
+
+// skipItem reads the next item without decoding its value, keys are still
+// added to the key table as later references may need them
+func (d *decoder) skipItem() (encodeType, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return encodeTypeInvalid, err
+	}
+
+	typ := encodeType(b)
+	switch typ {
+	case encodeTypeString, encodeTypeNumber:
+		var length int64
+		if err = binary.Read(d.r, binary.LittleEndian, &length); err != nil {
+			break
+		}
+		if length < 0 {
+			return typ, fmt.Errorf("invalid string length %d", length)
+		}
+		_, err = io.CopyN(io.Discard, d.r, length)
+	case encodeTypeBool:
+		_, err = d.r.ReadByte()
+	case encodeTypeFloat64:
+		_, err = io.CopyN(io.Discard, d.r, 8)
+	case encodeTypeInt:
+		_, err = binary.ReadVarint(d.r)
+	case encodeTypeKeyDef:
+		_, err = d.decodeKeyDef()
+	case encodeTypeKeyRef:
+		_, err = d.decodeKeyRef()
+	case encodeTypeDocumentStart, encodeTypeDocumentEnd,
+		encodeTypeListStart, encodeTypeListEnd, encodeTypeNil:
+	default:
+		err = fmt.Errorf("decoding not supported for type %v", typ)
+	}
+	return typ, err
+}
+
 func decodeBool(r io.Reader) (bool, error) {
 	raw := make([]byte, 1)
 	l, err := r.Read(raw)
@@ -314,25 +619,96 @@ func decodeFloat64(r io.Reader) (float64, error) {
 	return num, nil
 }
 
-func decodeList(r io.Reader) ([]interface{}, error) {
-	var list []interface{}
+// decodeInt reads a zig-zag varint, returning it as a float64 as integers
+// are always float64 in a Document
+func decodeInt(r io.ByteReader) (float64, error) {
+	num, err := binary.ReadVarint(r)
+	if err != nil {
+		return 0, err
+	}
+	return float64(num), nil
+}
+
+// byteReader adapts an io.Reader for reading varints
+type byteReader struct {
+	io.Reader
+	buf [1]byte
+}
+
+func (b *byteReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(b.Reader, b.buf[:])
+	return b.buf[0], err
+}
+
+func asByteReader(r io.Reader) io.ByteReader {
+	if br, ok := r.(io.ByteReader); ok {
+		return br
+	}
+	return &byteReader{Reader: r}
+}
+
+// limitReader counts the bytes read, failing with a LimitError once more
+// than max have been read
+type limitReader struct {
+	r   io.Reader
+	br  io.ByteReader
+	n   int64
+	max int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return n, &LimitError{Limit: "MaxBytes", Max: l.max, Offset: l.max}
+	}
+	return n, err
+}
+
+func (l *limitReader) ReadByte() (byte, error) {
+	b, err := l.br.ReadByte()
+	if err != nil {
+		return b, err
+	}
+	l.n++
+	if l.n > l.max {
+		return b, &LimitError{Limit: "MaxBytes", Max: l.max, Offset: l.max}
+	}
+	return b, nil
+}
+
+func (d *decoder) decodeList() ([]interface{}, error) {
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+
+	// empty lists decode as [] rather than nil, as they do from JSON
+	list := []interface{}{}
 
 	for {
-		encType, item, err := nextItem(r)
+		encType, item, err := d.nextItem()
 		if err != nil {
 			return nil, err
 		}
+		if encType != encodeTypeListEnd {
+			if err := d.element(); err != nil {
+				return nil, err
+			}
+		}
 		// check the encodeType and act accordingly
 		switch encType {
 		case encodeTypeListEnd:
 			return list, nil
 		case encodeTypeDocumentStart:
-			item, err = decodeDocument(r)
+			item, err = d.decodeDocument()
 		case encodeTypeListStart:
-			item, err = decodeList(r)
+			item, err = d.decodeList()
 		case encodeTypeString:
 		case encodeTypeBool:
 		case encodeTypeFloat64:
+		case encodeTypeNumber:
+		case encodeTypeInt:
 		case encodeTypeNil:
 		default:
 			return nil, fmt.Errorf("top level decoding not supported for type %v", encType)
@@ -3,6 +3,7 @@ package apidoc
 import (
 	"bytes"
 	"encoding/json"
+	"math"
 	"testing"
 )
 
@@ -64,6 +65,99 @@ func TestEncodeDecode(t *testing.T) {
 	}
 }
 
+func TestEncodeDecodeNumber(t *testing.T) {
+	var doc Document
+	ok(t, JSONOptions{UseNumber: true}.Unmarshal(loadTestData(t, "departure.json"), &doc))
+	ok(t, doc.SetPath(json.Number("12345678901234567890"), "big_id"))
+	equals(t, json.Number("5"), doc["rooms"].([]interface{})[0].(Document)["availability"].(Document)["total"])
+
+	data, err := doc.MarshalBinary()
+	ok(t, err)
+	var doc2 Document
+	ok(t, doc2.UnmarshalBinary(data))
+	assert(t, doc.Equal(doc2), "number documents should round trip %s", FormatDiff(Diff(doc, doc2)))
+	equals(t, json.Number("12345678901234567890"), doc2["big_id"])
+
+	tag, err := doc.ETag()
+	ok(t, err)
+	tag2, err := doc2.ETag()
+	ok(t, err)
+	equals(t, tag, tag2)
+
+	ok(t, doc2.SetPath(json.Number("12345678901234567891"), "big_id"))
+	tag2, err = doc2.ETag()
+	ok(t, err)
+	assert(t, tag != tag2, "large ids should not collide")
+}
+
+func TestEncodeDecodeInt(t *testing.T) {
+	nums := []interface{}{
+		0.0, 1.0, -1.0, 63.0, 64.0, -65.0, 733048.0,
+		float64(maxSafeInteger), -float64(maxSafeInteger), float64(maxSafeInteger + 1),
+		1.5, -0.25, 1e300, math.Copysign(0, -1), nil,
+	}
+	doc := Document{"nums": nums, "id": 733048.0}
+
+	compact := new(bytes.Buffer)
+	e := encoder{w: compact, compactInts: true}
+	ok(t, e.encodeDocument(doc))
+	legacy := new(bytes.Buffer)
+	ok(t, encodeDocument(legacy, doc, false))
+	assert(t, compact.Len() < legacy.Len(), "compact encoding should be smaller %d >= %d", compact.Len(), legacy.Len())
+
+	// both the compact and the older float64 only encoding decode the same
+	for _, buf := range []*bytes.Buffer{compact, legacy} {
+		v, err := decodeValue(buf)
+		ok(t, err)
+		decoded := v.(Document)
+		assert(t, doc.Equal(decoded), "expected %v got %v", doc, decoded)
+		negZero := decoded["nums"].([]interface{})[13].(float64)
+		assert(t, math.Signbit(negZero), "negative zero should keep its sign")
+	}
+}
+
+func TestETagUnchangedByCompactInts(t *testing.T) {
+	doc := loadDeparture(t)
+	tag, err := doc.ETag()
+	ok(t, err)
+	equals(t, "8e0005114d468dae", tag.String())
+
+	data, err := doc.MarshalBinary()
+	ok(t, err)
+	var doc2 Document
+	ok(t, doc2.UnmarshalBinary(data))
+	assert(t, doc.Equal(doc2), "departure should round trip")
+	tag2, err := doc2.ETag()
+	ok(t, err)
+	equals(t, tag, tag2)
+}
+
+func TestEncodeListWithNil(t *testing.T) {
+	// a nil item used to end the list encoding early, without a ListEnd
+	doc := Document{"a": []interface{}{1.0, nil, 2.0}}
+	buf := new(bytes.Buffer)
+	ok(t, encodeDocument(buf, doc, true))
+	exp := []byte{
+		byte(encodeTypeDocumentStart),
+		byte(encodeTypeString), 1, 0, 0, 0, 0, 0, 0, 0, 'a',
+		byte(encodeTypeListStart),
+		byte(encodeTypeFloat64), 0, 0, 0, 0, 0, 0, 0xf0, 0x3f,
+		byte(encodeTypeNil),
+		byte(encodeTypeFloat64), 0, 0, 0, 0, 0, 0, 0, 0x40,
+		byte(encodeTypeListEnd),
+		byte(encodeTypeDocumentEnd),
+	}
+	equals(t, exp, buf.Bytes())
+
+	v, err := decodeValue(buf)
+	ok(t, err)
+	assert(t, doc.Equal(v.(Document)), "expected %v got %v", doc, v)
+
+	tag, err := doc.ETag()
+	ok(t, err)
+	equals(t, "3bf2b96e747258e9", tag.String())
+}
+
 // to run benchmarks
 // go test -v -bench Benchmark -run Benchmark -count 3
 
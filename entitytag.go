@@ -0,0 +1,131 @@
+package apidoc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EntityTag is an HTTP entity tag as used by the ETag, If-Match and
+// If-None-Match headers (RFC 9110 section 8.8.3)
+type EntityTag struct {
+	// Tag is the opaque tag, without quotes
+	Tag string
+	// Weak tags only signal that representations are equivalent
+	Weak bool
+}
+
+// String returns the header form of the tag, e.g. "8e0005114d468dae" or
+// W/"8e0005114d468dae"
+func (t EntityTag) String() string {
+	if t.Weak {
+		return `W/"` + t.Tag + `"`
+	}
+	return `"` + t.Tag + `"`
+}
+
+// EntityTag returns the ETag as an HTTP entity tag
+func (e ETag) EntityTag(weak bool) EntityTag {
+	return EntityTag{Tag: e.String(), Weak: weak}
+}
+
+// HTTPHeader returns the value of an ETag header for the ETag
+func (e ETag) HTTPHeader(weak bool) string {
+	return e.EntityTag(weak).String()
+}
+
+// EntityTag returns the Digest as an HTTP entity tag
+func (dg Digest) EntityTag(weak bool) EntityTag {
+	return EntityTag{Tag: dg.String(), Weak: weak}
+}
+
+// HTTPHeader returns the value of an ETag header for the Digest
+func (dg Digest) HTTPHeader(weak bool) string {
+	return dg.EntityTag(weak).String()
+}
+
+// EntityTags are the entity tags of an If-Match or If-None-Match header
+type EntityTags struct {
+	// Any is set for *, which matches any current representation
+	Any  bool
+	Tags []EntityTag
+}
+
+// ParseEntityTags parses the value of an If-Match or If-None-Match header,
+// either * or a comma separated list of entity tags. An empty value holds
+// no tags.
+func ParseEntityTags(header string) (EntityTags, error) {
+	var tags EntityTags
+	s := strings.Trim(header, " \t")
+	if s == "*" {
+		tags.Any = true
+		return tags, nil
+	}
+	for len(s) > 0 {
+		// empty list elements are allowed
+		if s[0] == ',' || s[0] == ' ' || s[0] == '\t' {
+			s = s[1:]
+			continue
+		}
+		var (
+			tag EntityTag
+			err error
+		)
+		tag, s, err = parseEntityTag(s)
+		if err != nil {
+			return EntityTags{}, fmt.Errorf("invalid entity tags %q: %w", header, err)
+		}
+		tags.Tags = append(tags.Tags, tag)
+		s = strings.TrimLeft(s, " \t")
+		if len(s) > 0 && s[0] != ',' {
+			return EntityTags{}, fmt.Errorf("invalid entity tags %q: expected comma after %s", header, tag)
+		}
+	}
+	return tags, nil
+}
+
+// parseEntityTag parses the entity tag at the start of s, returning the rest
+func parseEntityTag(s string) (EntityTag, string, error) {
+	var tag EntityTag
+	if strings.HasPrefix(s, "W/") {
+		tag.Weak = true
+		s = s[2:]
+	}
+	if len(s) == 0 || s[0] != '"' {
+		return tag, s, fmt.Errorf("entity tag %q is not quoted", s)
+	}
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			tag.Tag = s[1:i]
+			return tag, s[i+1:], nil
+		case c == 0x21 || (c >= 0x23 && c <= 0x7E) || c >= 0x80:
+		default:
+			return tag, s, fmt.Errorf("invalid character %q in entity tag", c)
+		}
+	}
+	return tag, s, fmt.Errorf("entity tag %q is not terminated", s)
+}
+
+// Matches reports if the current entity tag of a representation matches any
+// of the tags. If-Match uses the strong comparison, where weak tags never
+// match, and If-None-Match the weak comparison, e.g.
+//
+//	tags, err := apidoc.ParseEntityTags(r.Header.Get("If-None-Match"))
+//	if err == nil && tags.Matches(etag.EntityTag(false), false) {
+//		w.WriteHeader(http.StatusNotModified)
+//		return
+//	}
+func (l EntityTags) Matches(current EntityTag, strong bool) bool {
+	if l.Any {
+		return true
+	}
+	for _, tag := range l.Tags {
+		if tag.Tag != current.Tag {
+			continue
+		}
+		if !strong || (!tag.Weak && !current.Weak) {
+			return true
+		}
+	}
+	return false
+}
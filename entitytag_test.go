@@ -0,0 +1,124 @@
+package apidoc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEntityTagHeader(t *testing.T) {
+	equals(t, `"8e0005114d468dae"`, ETag(0x8e0005114d468dae).HTTPHeader(false))
+	equals(t, `W/"8e0005114d468dae"`, ETag(0x8e0005114d468dae).HTTPHeader(true))
+
+	dg, err := NewDigest("xxh64:d24ec4f1a98c6e5b")
+	ok(t, err)
+	equals(t, `"xxh64:d24ec4f1a98c6e5b"`, dg.HTTPHeader(false))
+
+	for _, tag := range []EntityTag{ETag(0xabc).EntityTag(false), ETag(0xabc).EntityTag(true), dg.EntityTag(true)} {
+		tags, err := ParseEntityTags(tag.String())
+		ok(t, err)
+		equals(t, []EntityTag{tag}, tags.Tags)
+	}
+}
+
+func TestParseEntityTags(t *testing.T) {
+	for header, exp := range map[string]EntityTags{
+		``:                    {},
+		`*`:                   {Any: true},
+		` * `:                 {Any: true},
+		`""`:                  {Tags: []EntityTag{{}}},
+		`"abc"`:               {Tags: []EntityTag{{Tag: "abc"}}},
+		`W/"abc"`:             {Tags: []EntityTag{{Tag: "abc", Weak: true}}},
+		`"a", W/"b" ,"c"`:     {Tags: []EntityTag{{Tag: "a"}, {Tag: "b", Weak: true}, {Tag: "c"}}},
+		`, "a",,"b",`:         {Tags: []EntityTag{{Tag: "a"}, {Tag: "b"}}},
+		`"a*b", "x:y"`:        {Tags: []EntityTag{{Tag: "a*b"}, {Tag: "x:y"}}},
+		"\t\"a\"\t,\t\"b\"\t": {Tags: []EntityTag{{Tag: "a"}, {Tag: "b"}}},
+	} {
+		tags, err := ParseEntityTags(header)
+		ok(t, err)
+		equals(t, exp, tags)
+	}
+
+	for _, header := range []string{`abc`, `"abc`, `"a" "b"`, `w/"a"`, `W/ "a"`, `"a b"`, `"a"b`, `*, "a"`, `"a", *`} {
+		_, err := ParseEntityTags(header)
+		assert(t, err != nil, "expected error parsing %q", header)
+	}
+}
+
+func TestEntityTagsMatches(t *testing.T) {
+	strong := EntityTag{Tag: "1"}
+	weak := EntityTag{Tag: "1", Weak: true}
+	for _, tc := range []struct {
+		header      string
+		current     EntityTag
+		strongMatch bool
+		weakMatch   bool
+	}{
+		// RFC 9110 section 8.8.3.2
+		{`W/"1"`, weak, false, true},
+		{`W/"1"`, EntityTag{Tag: "2", Weak: true}, false, false},
+		{`W/"1"`, strong, false, true},
+		{`"1"`, strong, true, true},
+		{`"1"`, weak, false, true},
+		{`"2", "1"`, strong, true, true},
+		{`*`, weak, true, true},
+		{``, strong, false, false},
+	} {
+		tags, err := ParseEntityTags(tc.header)
+		ok(t, err)
+		equals(t, tc.strongMatch, tags.Matches(tc.current, true))
+		equals(t, tc.weakMatch, tags.Matches(tc.current, false))
+	}
+}
+
+func TestEntityTagsHandler(t *testing.T) {
+	doc := loadDeparture(t)
+	etag, err := doc.ETag()
+	ok(t, err)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := etag.EntityTag(false)
+		if h := r.Header.Get("If-Match"); h != "" {
+			tags, err := ParseEntityTags(h)
+			if err != nil || !tags.Matches(current, true) {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+		}
+		w.Header().Set("ETag", current.String())
+		if h := r.Header.Get("If-None-Match"); h != "" {
+			tags, err := ParseEntityTags(h)
+			if err == nil && tags.Matches(current, false) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tc := range []struct {
+		header, value string
+		status        int
+	}{
+		{"", "", http.StatusOK},
+		{"If-None-Match", etag.HTTPHeader(false), http.StatusNotModified},
+		{"If-None-Match", etag.HTTPHeader(true), http.StatusNotModified},
+		{"If-None-Match", `"0", ` + etag.HTTPHeader(false), http.StatusNotModified},
+		{"If-None-Match", `"0"`, http.StatusOK},
+		{"If-Match", etag.HTTPHeader(false), http.StatusOK},
+		{"If-Match", etag.HTTPHeader(true), http.StatusPreconditionFailed},
+		{"If-Match", `"0"`, http.StatusPreconditionFailed},
+		{"If-Match", `*`, http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/departures/733048", nil)
+		if tc.header != "" {
+			r.Header.Set(tc.header, tc.value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		equals(t, tc.status, w.Code)
+		if w.Code != http.StatusPreconditionFailed {
+			equals(t, `"8e0005114d468dae"`, w.Header().Get("ETag"))
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package apidoc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxErrorBodySize limits how much of an error response body is read
+const maxErrorBodySize = 1 << 20
+
+// sentinel errors an *ErrGAPI matches with errors.Is, based on its
+// HTTPStatusCode
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrServerError  = errors.New("server error")
+)
+
+// ErrGAPI type represents error response returned by GAPI
+//
+// See: https://developers.gadventures.com/docs/rest.html#errors
+type ErrGAPI struct {
+	HTTPStatusCode int
+	Message        string
+	ErrorID        string
+	URI            string
+	Time           time.Time
+	Errors         []FieldError
+	RequestID      string
+	RetryAfter     time.Duration
+}
+
+// FieldError is a single entry of the errors list in a GAPI error response,
+// usually describing a problem with one field of the request. Detail holds
+// the raw entry when it was an object.
+type FieldError struct {
+	Field   string
+	Message string
+	Detail  Document
+}
+
+// Error satisfies the error interface for the ErrGAPI type
+func (r *ErrGAPI) Error() string {
+	return fmt.Sprintf("GAPI error for %s HTTP Status %d %s %s", r.URI, r.HTTPStatusCode, r.ErrorID, r.Message)
+}
+
+// Is reports if the error matches one of the sentinel errors for its
+// HTTPStatusCode, any 5xx status matches ErrServerError
+//
+// e.g. errors.Is(err, apidoc.ErrNotFound)
+func (r *ErrGAPI) Is(target error) bool {
+	if r == nil {
+		return false
+	}
+	switch target {
+	case ErrBadRequest:
+		return r.HTTPStatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return r.HTTPStatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return r.HTTPStatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return r.HTTPStatusCode == http.StatusNotFound
+	case ErrConflict:
+		return r.HTTPStatusCode == http.StatusConflict
+	case ErrRateLimited:
+		return r.HTTPStatusCode == http.StatusTooManyRequests
+	case ErrServerError:
+		return r.HTTPStatusCode >= 500 && r.HTTPStatusCode < 600
+	default:
+		return false
+	}
+}
+
+// Temporary reports if the error is expected to clear up on its own, i.e.
+// timeouts, rate limiting and unavailable or overloaded upstreams
+func (r *ErrGAPI) Temporary() bool {
+	if r == nil {
+		return false
+	}
+	switch r.HTTPStatusCode {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// Retryable reports if repeating the same request may succeed, which is
+// the case for Temporary errors and internal server errors
+func (r *ErrGAPI) Retryable() bool {
+	if r == nil {
+		return false
+	}
+	return r.Temporary() || r.HTTPStatusCode == http.StatusInternalServerError
+}
+
+// IsRetryable reports if err, or any error it wraps, is a retryable *ErrGAPI
+func IsRetryable(err error) bool {
+	var gerr *ErrGAPI
+	return errors.As(err, &gerr) && gerr.Retryable()
+}
+
+// IsTemporary reports if err, or any error it wraps, is a temporary *ErrGAPI
+func IsTemporary(err error) bool {
+	var gerr *ErrGAPI
+	return errors.As(err, &gerr) && gerr.Temporary()
+}
+
+// gapiTimeLayouts are the layouts tried when parsing the time of an error,
+// GAPI does not include a timezone and reports times in UTC
+var gapiTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05.999999999",
+	time.RFC3339Nano,
+}
+
+// GAPIError returns *ErrGAPI if the document is a GAPI error, nil otherwise
+func (d Document) GAPIError(uri string) *ErrGAPI {
+	_, hasError := d["error_id"]
+	if !hasError {
+		return nil
+	}
+	errID, _ := d["error_id"].(string)
+	msg, _ := d["message"].(string)
+	return &ErrGAPI{
+		ErrorID:        errID,
+		HTTPStatusCode: intValue(d["http_status_code"]),
+		Message:        msg,
+		URI:            uri,
+		Time:           gapiErrorTime(d["time"]),
+		Errors:         gapiFieldErrors(d["errors"]),
+	}
+}
+
+// intValue returns numbers (float64 as decoded by UnmarshalJSON) as an int
+func intValue(v interface{}) int {
+	switch n := v.(type) {
+	case float64:
+		return int(n)
+	case json.Number:
+		i, _ := n.Int64()
+		return int(i)
+	case int:
+		return n
+	default:
+		return 0
+	}
+}
+
+func gapiErrorTime(v interface{}) time.Time {
+	s, _ := v.(string)
+	for _, layout := range gapiTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func gapiFieldErrors(v interface{}) []FieldError {
+	list, _ := v.([]interface{})
+	if len(list) == 0 {
+		return nil
+	}
+	errs := make([]FieldError, 0, len(list))
+	for _, item := range list {
+		switch t := item.(type) {
+		case string:
+			errs = append(errs, FieldError{Message: t})
+		case Document:
+			fe := FieldError{Detail: t}
+			fe.Field, _ = t["field"].(string)
+			if fe.Field == "" {
+				fe.Field, _ = t["name"].(string)
+			}
+			fe.Message, _ = t["message"].(string)
+			if fe.Message == "" {
+				fe.Message, _ = t["error"].(string)
+			}
+			errs = append(errs, fe)
+		}
+	}
+	return errs
+}
+
+// ErrGAPIFromResponse returns *ErrGAPI for a response with a 4xx or 5xx
+// status, nil otherwise or for a nil response. The body is decoded as a
+// Document and used if it is a GAPI error, otherwise the error is built from
+// the status line. The Retry-After and X-Request-Id headers are captured in
+// both cases.
+//
+// The response body is read but not closed, the caller must close resp.Body.
+func ErrGAPIFromResponse(resp *http.Response) *ErrGAPI {
+	if resp == nil || resp.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+	var uri string
+	if resp.Request != nil && resp.Request.URL != nil {
+		uri = resp.Request.URL.String()
+	}
+
+	var gerr *ErrGAPI
+	var body []byte
+	if resp.Body != nil {
+		body, _ = io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	}
+	var doc Document
+	if err := doc.UnmarshalJSON(body); err == nil {
+		gerr = doc.GAPIError(uri)
+	}
+	if gerr == nil {
+		gerr = &ErrGAPI{
+			Message: strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode))),
+			URI:     uri,
+		}
+		if gerr.Message == "" {
+			gerr.Message = http.StatusText(resp.StatusCode)
+		}
+		if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+			gerr.Time = date.UTC()
+		}
+	}
+	if gerr.HTTPStatusCode == 0 {
+		gerr.HTTPStatusCode = resp.StatusCode
+	}
+	gerr.RequestID = resp.Header.Get("X-Request-Id")
+	gerr.RetryAfter = retryAfter(resp.Header, time.Now())
+	return gerr
+}
+
+// retryAfter parses the Retry-After header, which is either a number of
+// seconds or an HTTP date
+func retryAfter(h http.Header, now time.Time) time.Duration {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	at, err := http.ParseTime(v)
+	if err != nil {
+		return 0
+	}
+	if date, err := http.ParseTime(h.Get("Date")); err == nil {
+		now = date
+	}
+	if d := at.Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
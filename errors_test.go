@@ -0,0 +1,162 @@
+package apidoc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGAPIErrorFixture(t *testing.T) {
+	var doc Document
+	ok(t, json.Unmarshal(loadTestData(t, "gapi_error.json"), &doc))
+
+	uri := "https://rest.gadventures.com/departures/7330489"
+	gerr := doc.GAPIError(uri)
+	assert(t, gerr != nil, "expected a GAPIError")
+	equals(t, 404, gerr.HTTPStatusCode)
+	equals(t, "gapi_8cef0f3ad5e54ad2bab20493b896ef9f", gerr.ErrorID)
+	equals(t, "No such departures with ID 7330489", gerr.Message)
+	equals(t, uri, gerr.URI)
+	equals(t, time.Date(2017, 2, 10, 17, 30, 11, 0, time.UTC), gerr.Time)
+	equals(t, 0, len(gerr.Errors))
+	equals(t, "GAPI error for "+uri+" HTTP Status 404 gapi_8cef0f3ad5e54ad2bab20493b896ef9f No such departures with ID 7330489", gerr.Error())
+}
+
+func TestGAPIErrorFieldErrors(t *testing.T) {
+	doc := mustDocument(t, `{
+		"http_status_code": 400,
+		"time": "2017-02-10T17:30:11.123Z",
+		"message": "Invalid request",
+		"error_id": "gapi_1",
+		"errors": [
+			{"field": "date_of_birth", "message": "This field is required."},
+			{"name": "nationality", "error": "Unknown country."},
+			"Request body is not valid JSON."
+		]
+	}`)
+	gerr := doc.GAPIError("")
+	equals(t, 400, gerr.HTTPStatusCode)
+	equals(t, time.Date(2017, 2, 10, 17, 30, 11, 123000000, time.UTC), gerr.Time)
+	equals(t, []FieldError{
+		{Field: "date_of_birth", Message: "This field is required.", Detail: doc["errors"].([]interface{})[0].(Document)},
+		{Field: "nationality", Message: "Unknown country.", Detail: doc["errors"].([]interface{})[1].(Document)},
+		{Message: "Request body is not valid JSON."},
+	}, gerr.Errors)
+
+	// malformed values are ignored
+	gerr = Document{"error_id": "gapi_2", "time": "yesterday", "http_status_code": "500"}.GAPIError("")
+	equals(t, 0, gerr.HTTPStatusCode)
+	assert(t, gerr.Time.IsZero(), "expected zero time")
+}
+
+func TestErrGAPIIs(t *testing.T) {
+	sentinels := []error{
+		ErrBadRequest, ErrUnauthorized, ErrForbidden, ErrNotFound,
+		ErrConflict, ErrRateLimited, ErrServerError,
+	}
+	cases := []struct {
+		status    int
+		match     error
+		temporary bool
+		retryable bool
+	}{
+		{400, ErrBadRequest, false, false},
+		{401, ErrUnauthorized, false, false},
+		{403, ErrForbidden, false, false},
+		{404, ErrNotFound, false, false},
+		{408, nil, true, true},
+		{409, ErrConflict, false, false},
+		{429, ErrRateLimited, true, true},
+		{500, ErrServerError, false, true},
+		{502, ErrServerError, true, true},
+		{503, ErrServerError, true, true},
+		{504, ErrServerError, true, true},
+		{599, ErrServerError, false, false},
+		{0, nil, false, false},
+	}
+	for _, c := range cases {
+		var err error = fmt.Errorf("fetching departure: %w", &ErrGAPI{HTTPStatusCode: c.status})
+		for _, sentinel := range sentinels {
+			equals(t, sentinel == c.match, errors.Is(err, sentinel))
+		}
+		assert(t, IsTemporary(err) == c.temporary, "status %d temporary should be %v", c.status, c.temporary)
+		assert(t, IsRetryable(err) == c.retryable, "status %d retryable should be %v", c.status, c.retryable)
+	}
+	assert(t, !IsRetryable(errors.New("boom")), "plain errors are not retryable")
+	assert(t, !IsTemporary(nil), "nil is not temporary")
+
+	// a typed nil, e.g. from Document.GAPIError, wrapped in an error
+	var gerr *ErrGAPI
+	err := fmt.Errorf("fetching departure: %w", gerr)
+	assert(t, !errors.Is(err, ErrNotFound), "typed nil should not match")
+	assert(t, !IsRetryable(err), "typed nil is not retryable")
+	assert(t, !IsTemporary(err), "typed nil is not temporary")
+}
+
+func TestErrGAPIFromResponse(t *testing.T) {
+	gapiError := loadTestData(t, "gapi_error.json")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/departures/733048", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(loadTestData(t, "departure.json"))
+	})
+	mux.HandleFunc("/departures/7330489", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Id", "req-404")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(gapiError)
+	})
+	mux.HandleFunc("/throttled", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.Header().Set("X-Request-Id", "req-429")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("slow down"))
+	})
+	mux.HandleFunc("/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", "Fri, 10 Feb 2017 17:30:00 GMT")
+		w.Header().Set("Retry-After", "Fri, 10 Feb 2017 17:35:00 GMT")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("<html>down for maintenance</html>"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	get := func(path string) *http.Response {
+		resp, err := http.Get(srv.URL + path)
+		ok(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	assert(t, ErrGAPIFromResponse(get("/departures/733048")) == nil, "expected no error for 200")
+	// as passed through when http.Get fails
+	assert(t, ErrGAPIFromResponse(nil) == nil, "expected no error for a nil response")
+
+	gerr := ErrGAPIFromResponse(get("/departures/7330489"))
+	equals(t, 404, gerr.HTTPStatusCode)
+	equals(t, "gapi_8cef0f3ad5e54ad2bab20493b896ef9f", gerr.ErrorID)
+	equals(t, "No such departures with ID 7330489", gerr.Message)
+	equals(t, srv.URL+"/departures/7330489", gerr.URI)
+	equals(t, time.Date(2017, 2, 10, 17, 30, 11, 0, time.UTC), gerr.Time)
+	equals(t, "req-404", gerr.RequestID)
+	assert(t, errors.Is(gerr, ErrNotFound), "expected ErrNotFound")
+
+	gerr = ErrGAPIFromResponse(get("/throttled"))
+	equals(t, 429, gerr.HTTPStatusCode)
+	equals(t, "Too Many Requests", gerr.Message)
+	equals(t, "", gerr.ErrorID)
+	equals(t, "req-429", gerr.RequestID)
+	equals(t, 2*time.Minute, gerr.RetryAfter)
+	assert(t, gerr.Retryable(), "expected retryable")
+
+	gerr = ErrGAPIFromResponse(get("/maintenance"))
+	equals(t, 503, gerr.HTTPStatusCode)
+	equals(t, "Service Unavailable", gerr.Message)
+	equals(t, 5*time.Minute, gerr.RetryAfter)
+	equals(t, time.Date(2017, 2, 10, 17, 30, 0, 0, time.UTC), gerr.Time)
+	assert(t, errors.Is(gerr, ErrServerError), "expected ErrServerError")
+}
@@ -1,8 +1,14 @@
 package apidoc
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"hash/fnv"
+	"strings"
 )
 
 // ETag is type for storing the calculated etag/checksum of a Document
@@ -12,8 +18,20 @@ func (e ETag) String() string {
 	return fmt.Sprintf("%x", uint64(e))
 }
 
-// NewETag returns new ETag initialized with provided hexadecimal string
+// NewETag returns new ETag initialized with provided hexadecimal string, or
+// with the string form of an FNV-64a Digest. Digests of other algorithms
+// are parsed with NewDigest, so that they are not compared with ETags.
 func NewETag(s string) (ETag, error) {
+	if strings.Contains(s, ":") {
+		dg, err := NewDigest(s)
+		if err != nil {
+			return 0, err
+		}
+		if dg.Algorithm != ETaggerFNV64a.Name() || len(dg.Sum) != 8 {
+			return 0, fmt.Errorf("%s digest is not an ETag, it is parsed with NewDigest", dg.Algorithm)
+		}
+		return dg.ETag(), nil
+	}
 	var e uint64
 	if _, err := fmt.Sscanf(s, "%x", &e); err != nil {
 		return ETag(e), err
@@ -27,3 +45,272 @@ func (d Document) ETag() (ETag, error) {
 	err := encodeDocument(h, d, true)
 	return ETag(h.Sum64()), err
 }
+
+// ETagger is a hash algorithm for calculating the checksum of Documents
+type ETagger interface {
+	// Name identifies the algorithm in the string form of a Digest
+	Name() string
+	// New returns a hash receiving the stable encoding of a Document
+	New() hash.Hash
+}
+
+// the ETaggers provided by this package
+var (
+	// ETaggerFNV64a is used by Document.ETag, it is fast but collisions are
+	// likely across large numbers of Documents
+	ETaggerFNV64a ETagger = etagger{"fnv64a", func() hash.Hash { return fnv.New64a() }}
+	// ETaggerXXHash64 is faster than FNV-64a and has fewer collisions
+	ETaggerXXHash64 ETagger = etagger{"xxh64", func() hash.Hash { return newXXHash64() }}
+	// ETaggerSHA256 is suitable as a content address
+	ETaggerSHA256 ETagger = etagger{"sha256", sha256.New}
+)
+
+type etagger struct {
+	name string
+	new  func() hash.Hash
+}
+
+func (e etagger) Name() string   { return e.name }
+func (e etagger) New() hash.Hash { return e.new() }
+
+// Digest is the checksum of a Document calculated by an ETagger, its string
+// form is the name of the algorithm and the hexadecimal sum,
+// e.g. xxh64:d24ec4f1a98c6e5b
+type Digest struct {
+	Algorithm string
+	Sum       []byte
+}
+
+func (dg Digest) String() string {
+	return dg.Algorithm + ":" + hex.EncodeToString(dg.Sum)
+}
+
+// Equal reports if both digests were calculated by the same algorithm and
+// hold the same sum
+func (dg Digest) Equal(other Digest) bool {
+	return dg.Algorithm == other.Algorithm && string(dg.Sum) == string(other.Sum)
+}
+
+// ETag returns the first 64 bits of the sum, which for 64 bit algorithms is
+// the whole sum
+func (dg Digest) ETag() ETag {
+	var b [8]byte
+	copy(b[:], dg.Sum)
+	return ETag(binary.BigEndian.Uint64(b[:]))
+}
+
+// NewDigest parses the string form of a Digest. A hexadecimal string without
+// an algorithm, as written by ETag.String, is read as FNV-64a.
+func NewDigest(s string) (Digest, error) {
+	alg, sum, found := strings.Cut(s, ":")
+	if !found {
+		// ETag.String does not pad the sum
+		e, err := NewETag(s)
+		if err != nil {
+			return Digest{}, fmt.Errorf("invalid digest %q: %w", s, err)
+		}
+		return Digest{Algorithm: ETaggerFNV64a.Name(), Sum: binary.BigEndian.AppendUint64(nil, uint64(e))}, nil
+	}
+	if alg == "" {
+		return Digest{}, errors.New("digest has no algorithm")
+	}
+	b, err := hex.DecodeString(sum)
+	if err != nil {
+		return Digest{}, fmt.Errorf("invalid digest %q: %w", s, err)
+	}
+	if len(b) == 0 {
+		return Digest{}, fmt.Errorf("invalid digest %q: empty sum", s)
+	}
+	return Digest{Algorithm: alg, Sum: b}, nil
+}
+
+// ETagOptions controls how the checksum of a Document is calculated
+type ETagOptions struct {
+	// ETagger is the hash algorithm, ETaggerFNV64a when not set
+	ETagger ETagger
+	// ExcludeKeys are left out of the checksum wherever they appear as a
+	// Document key, e.g. date_last_modified
+	ExcludeKeys []string
+	// ExcludePaths are left out of the checksum. PathWildcard segments match
+	// every item of a list or every value of a Document, e.g.
+	// rooms[*].availability. Excluded list items are left out as if they had
+	// been removed.
+	ExcludePaths []Path
+}
+
+// Digest returns the checksum of the Document
+func (o ETagOptions) Digest(d Document) (Digest, error) {
+	etagger := o.ETagger
+	if etagger == nil {
+		etagger = ETaggerFNV64a
+	}
+	x, err := o.exclusion()
+	if err != nil {
+		return Digest{}, err
+	}
+	if filtered, changed := x.filter(d, x.start()); changed {
+		d = filtered.(Document)
+	}
+	h := etagger.New()
+	if err := encodeDocument(h, d, true); err != nil {
+		return Digest{}, err
+	}
+	return Digest{Algorithm: etagger.Name(), Sum: h.Sum(nil)}, nil
+}
+
+// ETag returns the checksum of the Document as an ETag, see Digest.ETag
+func (o ETagOptions) ETag(d Document) (ETag, error) {
+	dg, err := o.Digest(d)
+	return dg.ETag(), err
+}
+
+// exclusion is the set of values left out of a checksum by ETagOptions
+type exclusion struct {
+	keys map[string]bool
+	root *exclusionNode
+}
+
+// exclusionNode is a segment of the excluded paths
+type exclusionNode struct {
+	children map[string]*exclusionNode
+	// end is set when the path ending at the node is excluded
+	end bool
+}
+
+// exclusion returns the values excluded by the options, nil when there are
+// none
+func (o ETagOptions) exclusion() (*exclusion, error) {
+	if len(o.ExcludeKeys) == 0 && len(o.ExcludePaths) == 0 {
+		return nil, nil
+	}
+	x := &exclusion{keys: make(map[string]bool, len(o.ExcludeKeys)), root: &exclusionNode{}}
+	for _, key := range o.ExcludeKeys {
+		x.keys[key] = true
+	}
+	for _, path := range o.ExcludePaths {
+		if len(path) == 0 {
+			return nil, fmt.Errorf("%w: empty exclude path", ErrInvalidPath)
+		}
+		n := x.root
+		for _, seg := range path {
+			child := n.children[seg]
+			if child == nil {
+				if n.children == nil {
+					n.children = make(map[string]*exclusionNode)
+				}
+				child = &exclusionNode{}
+				n.children[seg] = child
+			}
+			n = child
+		}
+		n.end = true
+	}
+	return x, nil
+}
+
+// start returns the nodes matching the root Document
+func (x *exclusion) start() []*exclusionNode {
+	if x == nil {
+		return nil
+	}
+	return []*exclusionNode{x.root}
+}
+
+// key returns the nodes matching the value at key of a Document matched by
+// active, and if that value is excluded
+func (x *exclusion) key(active []*exclusionNode, key string) ([]*exclusionNode, bool) {
+	if x == nil {
+		return nil, false
+	}
+	if x.keys[key] {
+		return nil, true
+	}
+	var next []*exclusionNode
+	for _, n := range active {
+		for _, seg := range [2]string{key, PathWildcard} {
+			if child := n.children[seg]; child != nil {
+				if child.end {
+					return nil, true
+				}
+				next = append(next, child)
+			}
+		}
+	}
+	return next, false
+}
+
+// item returns the nodes matching the item at idx of a list of n items
+// matched by active, and if that item is excluded
+func (x *exclusion) item(active []*exclusionNode, idx, n int) ([]*exclusionNode, bool) {
+	var next []*exclusionNode
+	for _, node := range active {
+		for seg, child := range node.children {
+			if i, ok := pathIndex(seg, n); seg == PathWildcard || (ok && i == idx) {
+				if child.end {
+					return nil, true
+				}
+				next = append(next, child)
+			}
+		}
+	}
+	return next, false
+}
+
+// filter returns v without the excluded values, only the Documents and lists
+// that changed are copied
+func (x *exclusion) filter(v interface{}, active []*exclusionNode) (interface{}, bool) {
+	if x == nil || (len(active) == 0 && len(x.keys) == 0) {
+		return v, false
+	}
+	switch t := v.(type) {
+	case Document:
+		var out Document
+		for key, val := range t {
+			next, skip := x.key(active, key)
+			changed := false
+			if !skip {
+				val, changed = x.filter(val, next)
+			}
+			if !skip && !changed {
+				continue
+			}
+			if out == nil {
+				out = make(Document, len(t))
+				for k, item := range t {
+					out[k] = item
+				}
+			}
+			if skip {
+				delete(out, key)
+			} else {
+				out[key] = val
+			}
+		}
+		if out == nil {
+			return t, false
+		}
+		return out, true
+	case []interface{}:
+		var out []interface{}
+		for i, item := range t {
+			next, skip := x.item(active, i, len(t))
+			changed := false
+			if !skip {
+				item, changed = x.filter(item, next)
+			}
+			if out == nil && (skip || changed) {
+				out = make([]interface{}, i, len(t))
+				copy(out, t[:i])
+			}
+			if out != nil && !skip {
+				out = append(out, item)
+			}
+		}
+		if out == nil {
+			return t, false
+		}
+		return out, true
+	default:
+		return v, false
+	}
+}
@@ -0,0 +1,183 @@
+package apidoc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestXXHash64(t *testing.T) {
+	for input, exp := range map[string]uint64{
+		"":    0xef46db3751d8e999,
+		"a":   0xd24ec4f1a98c6e5b,
+		"abc": 0x44bc2cf5ad770999,
+		"Nobody inspects the spammish repetition": 0xfbcea83c8a378bf1,
+	} {
+		h := newXXHash64()
+		h.Write([]byte(input))
+		equals(t, exp, h.Sum64())
+	}
+
+	// the sum does not depend on how the input is written
+	input := []byte(strings.Repeat("departure 733048 ", 20))
+	h := newXXHash64()
+	h.Write(input)
+	exp := h.Sum64()
+	for _, size := range []int{1, 3, 31, 32, 33} {
+		h.Reset()
+		for b := input; len(b) > 0; {
+			n := size
+			if n > len(b) {
+				n = len(b)
+			}
+			h.Write(b[:n])
+			b = b[n:]
+		}
+		equals(t, exp, h.Sum64())
+	}
+}
+
+func TestETagOptions(t *testing.T) {
+	doc := loadDeparture(t)
+	tag, err := doc.ETag()
+	ok(t, err)
+
+	// FNV-64a stays the default
+	dg, err := ETagOptions{}.Digest(doc)
+	ok(t, err)
+	equals(t, "fnv64a:"+tag.String(), dg.String())
+	tag2, err := ETagOptions{}.ETag(doc)
+	ok(t, err)
+	equals(t, tag, tag2)
+
+	for _, etagger := range []ETagger{ETaggerFNV64a, ETaggerXXHash64, ETaggerSHA256} {
+		o := ETagOptions{ETagger: etagger}
+		dg, err := o.Digest(doc)
+		ok(t, err)
+		equals(t, etagger.Name(), dg.Algorithm)
+		equals(t, etagger.New().Size(), len(dg.Sum))
+
+		parsed, err := NewDigest(dg.String())
+		ok(t, err)
+		assert(t, dg.Equal(parsed), "%s digest should round trip", etagger.Name())
+
+		// stable across copies and sensitive to changes
+		dg2, err := o.Digest(*doc.Copy())
+		ok(t, err)
+		assert(t, dg.Equal(dg2), "%s digest should be stable", etagger.Name())
+		changed := *doc.Copy()
+		changed["name"] = "changed"
+		dg2, err = o.Digest(changed)
+		ok(t, err)
+		assert(t, !dg.Equal(dg2), "%s digest should change", etagger.Name())
+
+		// only FNV-64a digests are ETags
+		tag, err := NewETag(dg.String())
+		if etagger.Name() == ETaggerFNV64a.Name() {
+			ok(t, err)
+			equals(t, dg.ETag(), tag)
+			equals(t, fmt.Sprintf("%016x", uint64(tag)), fmt.Sprintf("%x", dg.Sum))
+		} else {
+			assert(t, err != nil, "expected error parsing a %s digest as an ETag", etagger.Name())
+		}
+	}
+
+	legacy, err := NewDigest(tag.String())
+	ok(t, err)
+	equals(t, tag, legacy.ETag())
+	legacy, err = NewDigest(ETag(0xabc).String())
+	ok(t, err)
+	equals(t, ETag(0xabc), legacy.ETag())
+	for _, bad := range []string{"", ":abcd", "xxh64:", "xxh64:xyz"} {
+		_, err := NewDigest(bad)
+		assert(t, err != nil, "expected error parsing %q", bad)
+	}
+}
+
+func TestETagOptionsExclude(t *testing.T) {
+	availability, err := ParsePath("rooms[*].availability")
+	ok(t, err)
+	o := ETagOptions{ExcludeKeys: []string{"date_last_modified"}, ExcludePaths: []Path{availability}}
+
+	doc := loadDeparture(t)
+	dg, err := o.Digest(doc)
+	ok(t, err)
+	_, prs := doc["date_last_modified"]
+	assert(t, prs, "the Document should not be changed")
+
+	// the same as removing the values
+	removed := *doc.Copy()
+	ok(t, removed.DeletePath("date_last_modified"))
+	ok(t, removed.DeletePath("rooms", PathWildcard, "availability"))
+	exp, err := ETagOptions{}.Digest(removed)
+	ok(t, err)
+	equals(t, exp, dg)
+
+	// volatile changes are ignored
+	changed := *doc.Copy()
+	ok(t, changed.SetPath("2026-10-17T00:00:00Z", "date_last_modified"))
+	ok(t, changed.SetPath("2026-10-17T00:00:00Z", "rooms", "0", "date_last_modified"))
+	ok(t, changed.SetPath(0.0, "rooms", "0", "availability", "total"))
+	dg2, err := o.Digest(changed)
+	ok(t, err)
+	equals(t, dg, dg2)
+	ok(t, changed.SetPath("changed", "rooms", "0", "name"))
+	dg2, err = o.Digest(changed)
+	ok(t, err)
+	assert(t, !dg.Equal(dg2), "digest should change")
+
+	// list items
+	for path, exp := range map[string]Document{
+		"a[-1]":     {"a": []interface{}{1.0, 2.0}, "b": Document{"c": 1.0, "d": 2.0}},
+		"a[*]":      {"a": []interface{}{}, "b": Document{"c": 1.0, "d": 2.0}},
+		"b.*":       {"a": []interface{}{1.0, 2.0, 3.0}, "b": Document{}},
+		"b.d":       {"a": []interface{}{1.0, 2.0, 3.0}, "b": Document{"c": 1.0}},
+		"missing.x": {"a": []interface{}{1.0, 2.0, 3.0}, "b": Document{"c": 1.0, "d": 2.0}},
+	} {
+		p, err := ParsePath(path)
+		ok(t, err)
+		o := ETagOptions{ExcludePaths: []Path{p}}
+		tag, err := o.ETag(Document{"a": []interface{}{1.0, 2.0, 3.0}, "b": Document{"c": 1.0, "d": 2.0}})
+		ok(t, err)
+		expTag, err := exp.ETag()
+		ok(t, err)
+		equals(t, expTag, tag)
+	}
+
+	_, err = ETagOptions{ExcludePaths: []Path{{}}}.Digest(doc)
+	assert(t, errors.Is(err, ErrInvalidPath), "expected ErrInvalidPath got %v", err)
+}
+
+func TestETagTreeExclude(t *testing.T) {
+	o := ETagOptions{ExcludeKeys: []string{"href"}, ExcludePaths: []Path{{"rooms", "0", "price_bands", "-1"}}}
+	doc := loadDeparture(t)
+	tree, err := o.ETagTree(doc)
+	ok(t, err)
+
+	removed := *doc.Copy()
+	ok(t, removed.DeletePath("href"))
+	ok(t, removed.DeletePath("rooms", "0", "price_bands", "-1"))
+	plain, err := removed.ETagTree()
+	ok(t, err)
+	_, found := tree.Get("start_address", "country", "href")
+	assert(t, !found, "excluded keys should not be in the tree")
+	_, found = plain.Get("start_address", "country", "href")
+	assert(t, found, "href should be in the tree")
+	assert(t, plain.ETag() != tree.ETag(), "nested href should be excluded")
+
+	before := tree.ETag()
+	ok(t, doc.SetPath("https://example.com", "start_address", "country", "href"))
+	ok(t, tree.Update(doc, "start_address", "country", "href"))
+	equals(t, before, tree.ETag())
+	ok(t, doc.SetPath(0.0, "rooms", "0", "price_bands", "0", "prices", "0", "amount"))
+	ok(t, tree.Update(doc, "rooms", "0", "price_bands", "0", "prices", "0", "amount"))
+	equals(t, before, tree.ETag())
+
+	ok(t, doc.SetPath("changed", "start_address", "country", "name"))
+	ok(t, tree.Update(doc, "start_address", "country", "name"))
+	fresh, err := o.ETagTree(doc)
+	ok(t, err)
+	assert(t, before != tree.ETag(), "root should change")
+	equals(t, fresh.ETag(), tree.ETag())
+}
@@ -0,0 +1,331 @@
+package apidoc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"sort"
+	"strconv"
+)
+
+// ETagTree holds the checksum of every value of a Document. The checksum of
+// a nested Document or list is calculated from the keys and checksums of its
+// values, so a subtree that changed can be found without comparing the
+// values themselves, and the root can be recalculated from the checksums of
+// the unchanged values.
+//
+// The root checksum changes whenever Document.ETag would, but is not the
+// same value: Document.ETag hashes the whole encoded Document in one pass,
+// which cannot be updated from the checksums of the unchanged values. Keep
+// using Document.ETag for HTTP ETag headers, so that clients see a single
+// tag for a Document. An ETagTree is not safe for concurrent use.
+type ETagTree struct {
+	etagger ETagger
+	h       hash.Hash
+	x       *exclusion
+	root    etagNode
+}
+
+// etagNode is the checksum of a value, with the checksums of its values for
+// Documents and lists
+type etagNode struct {
+	etag ETag
+	// typ is encodeTypeDocumentStart or encodeTypeListStart for containers
+	typ encodeType
+	// keys are the sorted keys of a Document
+	keys     []string
+	children []etagNode
+}
+
+// ETagTree calculates the checksums of the Document and of every value
+// nested inside it, using FNV-64a
+func (d Document) ETagTree() (*ETagTree, error) {
+	return ETagOptions{}.ETagTree(d)
+}
+
+// ETagTree calculates the checksums of the Document and of every value
+// nested inside it. Checksums are the first 64 bits of the ETagger sums.
+// Excluded values are left out of the tree.
+func (o ETagOptions) ETagTree(d Document) (*ETagTree, error) {
+	etagger := o.ETagger
+	if etagger == nil {
+		etagger = ETaggerFNV64a
+	}
+	x, err := o.exclusion()
+	if err != nil {
+		return nil, err
+	}
+	t := &ETagTree{etagger: etagger, h: etagger.New(), x: x}
+	root, err := t.node(d, x.start())
+	if err != nil {
+		return nil, err
+	}
+	t.root = root
+	return t, nil
+}
+
+// ETag returns the checksum of the whole Document, which is not the same as
+// Document.ETag
+func (t *ETagTree) ETag() ETag {
+	return t.root.etag
+}
+
+// Get returns the checksum of the value at the provided path, negative
+// indexes count back from the end of lists. Wildcards are not supported.
+func (t *ETagTree) Get(parts ...string) (ETag, bool) {
+	n := &t.root
+	for _, seg := range parts {
+		var ok bool
+		if n, ok = n.child(seg); !ok {
+			return 0, false
+		}
+	}
+	return n.etag, true
+}
+
+// Update recalculates the checksums after the value at the provided path of
+// d was changed, e.g. by SetPath or DeletePath. Only the value at the path
+// is hashed again, the other values along it reuse their checksums, so d
+// must not have been changed anywhere else since the tree was calculated.
+//
+// Errors are returned as *PathError wrapping ErrInvalidPath for wildcards.
+func (t *ETagTree) Update(d Document, parts ...string) error {
+	for i, seg := range parts {
+		if seg == PathWildcard {
+			return &PathError{Op: "update", Path: Path(parts[:i+1]), Err: ErrInvalidPath}
+		}
+	}
+	root, err := t.update(t.root, d, parts, t.x.start())
+	if err != nil {
+		return err
+	}
+	t.root = root
+	return nil
+}
+
+// Changed returns the paths of the values that differ between the trees,
+// descending only into the Documents and lists whose checksums differ.
+// Documents are compared key by key in sorted order and lists index by
+// index, the same as Diff. Values that were added, removed or changed type
+// are reported as a whole.
+func (t *ETagTree) Changed(other *ETagTree) []Path {
+	var paths []Path
+	t.root.changed(nil, &other.root, func(p Path) {
+		paths = append(paths, p)
+	})
+	return paths
+}
+
+// node calculates the checksums of v, which is matched by the active
+// exclusions
+func (t *ETagTree) node(v interface{}, active []*exclusionNode) (etagNode, error) {
+	switch v := v.(type) {
+	case Document:
+		n := etagNode{typ: encodeTypeDocumentStart, keys: make([]string, 0, len(v))}
+		n.children = make([]etagNode, 0, len(v))
+		for _, key := range v.KeysSorted() {
+			next, skip := t.x.key(active, key)
+			if skip {
+				continue
+			}
+			child, err := t.node(v[key], next)
+			if err != nil {
+				return n, fmt.Errorf("key %s: %w", key, err)
+			}
+			n.keys = append(n.keys, key)
+			n.children = append(n.children, child)
+		}
+		n.etag = t.sum(n)
+		return n, nil
+	case []interface{}:
+		n := etagNode{typ: encodeTypeListStart, children: make([]etagNode, 0, len(v))}
+		for i, item := range v {
+			next, skip := t.x.item(active, i, len(v))
+			if skip {
+				continue
+			}
+			child, err := t.node(item, next)
+			if err != nil {
+				return n, fmt.Errorf("item at index %d: %w", i, err)
+			}
+			n.children = append(n.children, child)
+		}
+		n.etag = t.sum(n)
+		return n, nil
+	}
+
+	t.h.Reset()
+	var err error
+	switch v := v.(type) {
+	case nil:
+		err = encodeNil(t.h)
+	case bool:
+		err = encodeBool(t.h, v)
+	case float64:
+		err = encodeFloat64(t.h, v)
+	case json.Number:
+		err = encodeNumber(t.h, v)
+	case string:
+		err = encodeString(t.h, v)
+	default:
+		err = fmt.Errorf("unexpected type %T for value %v", v, v)
+	}
+	return etagNode{etag: t.etag()}, err
+}
+
+// sum calculates the checksum of a Document or list from its keys and the
+// checksums of its values
+func (t *ETagTree) sum(n etagNode) ETag {
+	t.h.Reset()
+	var buf [binary.MaxVarintLen64]byte
+	t.h.Write(n.typ.byteValue())
+	t.h.Write(buf[:binary.PutUvarint(buf[:], uint64(len(n.children)))])
+	for i, child := range n.children {
+		if n.typ == encodeTypeDocumentStart {
+			encodeString(t.h, n.keys[i])
+		}
+		binary.BigEndian.PutUint64(buf[:8], uint64(child.etag))
+		t.h.Write(buf[:8])
+	}
+	return t.etag()
+}
+
+func (t *ETagTree) etag() ETag {
+	if h, ok := t.h.(hash.Hash64); ok {
+		return ETag(h.Sum64())
+	}
+	return Digest{Algorithm: t.etagger.Name(), Sum: t.h.Sum(nil)}.ETag()
+}
+
+// update returns the checksums of v, reusing those of old for the values not
+// along parts
+func (t *ETagTree) update(old etagNode, v interface{}, parts []string, active []*exclusionNode) (etagNode, error) {
+	if len(parts) == 0 {
+		return t.node(v, active)
+	}
+	seg, rest := parts[0], parts[1:]
+	switch v := v.(type) {
+	case Document:
+		if old.typ != encodeTypeDocumentStart {
+			return t.node(v, active)
+		}
+		n := etagNode{typ: encodeTypeDocumentStart, keys: make([]string, 0, len(v))}
+		n.children = make([]etagNode, 0, len(v))
+		for _, key := range v.KeysSorted() {
+			next, skip := t.x.key(active, key)
+			if skip {
+				continue
+			}
+			var (
+				child etagNode
+				err   error
+			)
+			prev, found := old.child(key)
+			switch {
+			case !found:
+				child, err = t.node(v[key], next)
+			case key == seg:
+				child, err = t.update(*prev, v[key], rest, next)
+			default:
+				child = *prev
+			}
+			if err != nil {
+				return n, fmt.Errorf("key %s: %w", key, err)
+			}
+			n.keys = append(n.keys, key)
+			n.children = append(n.children, child)
+		}
+		n.etag = t.sum(n)
+		return n, nil
+	case []interface{}:
+		// items after an insertion or removal have shifted
+		if old.typ != encodeTypeListStart || len(old.children) != len(v) {
+			return t.node(v, active)
+		}
+		idx, ok := pathIndex(seg, len(v))
+		var next []*exclusionNode
+		for i := 0; i < len(v) && len(active) > 0; i++ {
+			// so have the items after an excluded one
+			matched, skip := t.x.item(active, i, len(v))
+			if skip {
+				return t.node(v, active)
+			}
+			if i == idx {
+				next = matched
+			}
+		}
+		n := etagNode{typ: encodeTypeListStart, children: make([]etagNode, len(v))}
+		copy(n.children, old.children)
+		if ok {
+			child, err := t.update(old.children[idx], v[idx], rest, next)
+			if err != nil {
+				return n, fmt.Errorf("item at index %d: %w", idx, err)
+			}
+			n.children[idx] = child
+		}
+		n.etag = t.sum(n)
+		return n, nil
+	default:
+		return t.node(v, active)
+	}
+}
+
+// child returns the checksums of the value at seg
+func (n *etagNode) child(seg string) (*etagNode, bool) {
+	switch n.typ {
+	case encodeTypeDocumentStart:
+		i := sort.SearchStrings(n.keys, seg)
+		if i < len(n.keys) && n.keys[i] == seg {
+			return &n.children[i], true
+		}
+	case encodeTypeListStart:
+		if idx, ok := pathIndex(seg, len(n.children)); ok {
+			return &n.children[idx], true
+		}
+	}
+	return nil, false
+}
+
+// changed calls fn with the paths of the values that differ between n and
+// other
+func (n *etagNode) changed(path Path, other *etagNode, fn func(Path)) {
+	if n.etag == other.etag && n.typ == other.typ {
+		return
+	}
+	switch {
+	case n.typ != other.typ || n.typ == encodeTypeInvalid:
+		fn(path)
+	case n.typ == encodeTypeDocumentStart:
+		// merge the sorted keys
+		i, j := 0, 0
+		for i < len(n.keys) || j < len(other.keys) {
+			switch {
+			case j == len(other.keys) || (i < len(n.keys) && n.keys[i] < other.keys[j]):
+				fn(appendPath(path, n.keys[i]))
+				i++
+			case i == len(n.keys) || other.keys[j] < n.keys[i]:
+				fn(appendPath(path, other.keys[j]))
+				j++
+			default:
+				n.children[i].changed(appendPath(path, n.keys[i]), &other.children[j], fn)
+				i++
+				j++
+			}
+		}
+	default:
+		common := len(n.children)
+		if len(other.children) < common {
+			common = len(other.children)
+		}
+		for i := 0; i < common; i++ {
+			n.children[i].changed(appendPath(path, strconv.Itoa(i)), &other.children[i], fn)
+		}
+		for i := common; i < len(other.children); i++ {
+			fn(appendPath(path, strconv.Itoa(i)))
+		}
+		for i := len(n.children) - 1; i >= common; i-- {
+			fn(appendPath(path, strconv.Itoa(i)))
+		}
+	}
+}
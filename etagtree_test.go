@@ -0,0 +1,149 @@
+package apidoc
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestETagTree(t *testing.T) {
+	doc := loadDeparture(t)
+	tree, err := doc.ETagTree()
+	ok(t, err)
+
+	tree2, err := (*doc.Copy()).ETagTree()
+	ok(t, err)
+	equals(t, tree.ETag(), tree2.ETag())
+	equals(t, 0, len(tree.Changed(tree2)))
+
+	// the checksum of a subtree is that of the subtree on its own
+	sub, err := doc["start_address"].(Document).ETagTree()
+	ok(t, err)
+	tag, found := tree.Get("start_address")
+	assert(t, found, "start_address should be found")
+	equals(t, sub.ETag(), tag)
+	tag, found = tree.Get()
+	assert(t, found, "root should be found")
+	equals(t, tree.ETag(), tag)
+
+	first, found := tree.Get("rooms", "0", "price_bands", "0")
+	assert(t, found, "first price band should be found")
+	last, found := tree.Get("rooms", "-1", "price_bands", "0")
+	assert(t, found, "last room should be found")
+	equals(t, first, last)
+	for _, path := range []Path{{"missing"}, {"rooms", "1"}, {"name", "x"}, {"rooms", PathWildcard}} {
+		_, found := tree.Get(path...)
+		assert(t, !found, "%s should not be found", path)
+	}
+
+	for _, etagger := range []ETagger{ETaggerXXHash64, ETaggerSHA256} {
+		other, err := ETagOptions{ETagger: etagger}.ETagTree(doc)
+		ok(t, err)
+		assert(t, other.ETag() != tree.ETag(), "%s tree should differ", etagger.Name())
+	}
+
+	_, err = Document{"a": []interface{}{1}}.ETagTree()
+	assert(t, err != nil, "expected error for an unsupported type")
+}
+
+func TestETagTreeChanged(t *testing.T) {
+	doc := loadDeparture(t)
+	tree, err := doc.ETagTree()
+	ok(t, err)
+
+	changed := *doc.Copy()
+	ok(t, changed.SetPath(3.0, "rooms", "0", "availability", "total"))
+	ok(t, changed.SetPath("new", "start_address", "country", "code"))
+	ok(t, changed.DeletePath("finish_address"))
+	ok(t, changed.SetPath("x", "tour"))
+	ok(t, changed.SetPath("FULL", "flags", "0"))
+	other, err := changed.ETagTree()
+	ok(t, err)
+	assert(t, tree.ETag() != other.ETag(), "root should change")
+
+	var paths []string
+	for _, p := range tree.Changed(other) {
+		paths = append(paths, p.String())
+	}
+	exp := []string{"finish_address", "flags[0]", "rooms[0].availability.total", "start_address.country.code", "tour"}
+	equals(t, exp, paths)
+
+	// the same changes are found as by Diff
+	var diffPaths []string
+	for _, c := range Diff(doc, changed) {
+		diffPaths = append(diffPaths, c.Path.String())
+	}
+	equals(t, diffPaths, paths)
+}
+
+func TestETagTreeUpdate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		path   Path
+		change func(Document) error
+	}{
+		{"scalar", Path{"rooms", "0", "availability", "total"}, func(d Document) error {
+			return d.SetPath(3.0, "rooms", "0", "availability", "total")
+		}},
+		{"negative index", Path{"rooms", "-1", "name"}, func(d Document) error {
+			return d.SetPath("Twin", "rooms", "-1", "name")
+		}},
+		{"new key", Path{"start_address", "country", "code"}, func(d Document) error {
+			return d.SetPath("ZW", "start_address", "country", "code")
+		}},
+		{"new document", Path{"extra", "a", "b"}, func(d Document) error {
+			return d.SetPath(true, "extra", "a", "b")
+		}},
+		{"deleted key", Path{"start_address", "postal_zip"}, func(d Document) error {
+			return d.DeletePath("start_address", "postal_zip")
+		}},
+		{"type change", Path{"start_address"}, func(d Document) error {
+			return d.SetPath([]interface{}{"x"}, "start_address")
+		}},
+		{"appended item", Path{"rooms", "1"}, func(d Document) error {
+			return d.SetPath(Document{"code": "X"}, "rooms", "1")
+		}},
+		{"deleted item", Path{"rooms", "0", "price_bands", "0"}, func(d Document) error {
+			return d.DeletePath("rooms", "0", "price_bands", "0")
+		}},
+		{"whole document", nil, func(d Document) error {
+			d["name"] = "changed"
+			return nil
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := loadDeparture(t)
+			tree, err := doc.ETagTree()
+			ok(t, err)
+			before := tree.ETag()
+
+			ok(t, tc.change(doc))
+			ok(t, tree.Update(doc, tc.path...))
+			fresh, err := doc.ETagTree()
+			ok(t, err)
+			equals(t, fresh.ETag(), tree.ETag())
+			assert(t, before != tree.ETag(), "root should change")
+			equals(t, 0, len(tree.Changed(fresh)))
+		})
+	}
+
+	doc := loadDeparture(t)
+	tree, err := doc.ETagTree()
+	ok(t, err)
+	err = tree.Update(doc, "rooms", PathWildcard, "name")
+	assert(t, errors.Is(err, ErrInvalidPath), "expected ErrInvalidPath got %v", err)
+}
+
+func BenchmarkETagTreeUpdate(b *testing.B) {
+	doc := bigSampleDoc(10)
+	tree, err := doc.ETagTree()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		doc.SetPath(float64(i), "foo", "children", "0", "notoriety")
+		if err := tree.Update(doc, "foo", "children", "0", "notoriety"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -19,6 +19,18 @@ func loadTestData(t *testing.T, filename string) []byte {
 	return data
 }
 
+func loadDeparture(t *testing.T) Document {
+	t.Helper()
+
+	var doc Document
+	err := json.Unmarshal(loadTestData(t, "departure.json"), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return doc
+}
+
 func TestDepartureBlob(t *testing.T) {
 	var (
 		doc, doc2 Document
@@ -0,0 +1,455 @@
+package apidoc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// The indexed layout, written with EncodeOptions.Indexed, gives random
+// access to the fields of a Document. Documents and lists are written as
+//
+//	type    uint8    IndexedDocument or IndexedList
+//	count   uvarint  number of keys or items
+//	size    uint32   size of the table and data that follow
+//	table            for Documents, count pairs of little endian uint32
+//	                 offsets of a key and its value, sorted by key.
+//	                 For lists, count uint32 offsets of the items.
+//	data             keys, written as a uvarint length and the bytes of the
+//	                 key, and values
+//
+// Offsets are relative to the start of data and strictly increasing, each
+// key or value ends where the next one starts. Scalar values are written as
+// in the streaming layout.
+
+// errIndexedCorrupt is returned when an offset or length does not fit
+var errIndexedCorrupt = errors.New("indexed layout is out of bounds")
+
+func encodeIndexed(buf *bytes.Buffer, val interface{}) error {
+	switch val := val.(type) {
+	case Document:
+		return encodeIndexedDocument(buf, val)
+	case []interface{}:
+		return encodeIndexedList(buf, val)
+	case nil:
+		return encodeNil(buf)
+	case string:
+		return encodeString(buf, val)
+	case bool:
+		return encodeBool(buf, val)
+	case float64:
+		e := encoder{w: buf, compactInts: true}
+		return e.encodeFloat64(val)
+	case json.Number:
+		return encodeNumber(buf, val)
+	default:
+		return fmt.Errorf("unexpected type %T for value %v", val, val)
+	}
+}
+
+func encodeIndexedDocument(buf *bytes.Buffer, doc Document) error {
+	keys := doc.KeysSorted()
+	table := make([]byte, 0, 8*len(keys))
+	var data bytes.Buffer
+	for _, key := range keys {
+		table = binary.LittleEndian.AppendUint32(table, uint32(data.Len()))
+		data.Write(binary.AppendUvarint(nil, uint64(len(key))))
+		data.WriteString(key)
+		table = binary.LittleEndian.AppendUint32(table, uint32(data.Len()))
+		if err := encodeIndexed(&data, doc[key]); err != nil {
+			return fmt.Errorf("key %s: %w", key, err)
+		}
+	}
+	return writeIndexedObject(buf, encodeTypeIndexedDocument, len(keys), table, data.Bytes())
+}
+
+func encodeIndexedList(buf *bytes.Buffer, list []interface{}) error {
+	table := make([]byte, 0, 4*len(list))
+	var data bytes.Buffer
+	for idx, val := range list {
+		table = binary.LittleEndian.AppendUint32(table, uint32(data.Len()))
+		if err := encodeIndexed(&data, val); err != nil {
+			return fmt.Errorf("item at index %d: %w", idx, err)
+		}
+	}
+	return writeIndexedObject(buf, encodeTypeIndexedList, len(list), table, data.Bytes())
+}
+
+func writeIndexedObject(buf *bytes.Buffer, typ encodeType, n int, table, data []byte) error {
+	size := uint64(len(table)) + uint64(len(data))
+	if size > math.MaxUint32 {
+		return fmt.Errorf("%v of %d bytes is too large for the indexed layout", typ, size)
+	}
+	var head [1 + binary.MaxVarintLen64 + 4]byte
+	head[0] = byte(typ)
+	l := 1 + binary.PutUvarint(head[1:], uint64(n))
+	binary.LittleEndian.PutUint32(head[l:], uint32(size))
+	buf.Write(head[:l+4])
+	buf.Write(table)
+	buf.Write(data)
+	return nil
+}
+
+// indexedObject is a Document or list of the indexed layout
+type indexedObject struct {
+	typ   encodeType
+	n     int
+	table []byte
+	data  []byte
+}
+
+// parseIndexedObject parses the Document or list at the start of b,
+// returning it and its size
+func parseIndexedObject(b []byte) (indexedObject, int, error) {
+	var o indexedObject
+	if len(b) == 0 {
+		return o, 0, io.ErrUnexpectedEOF
+	}
+	o.typ = encodeType(b[0])
+	entry := o.entrySize()
+	if entry == 0 {
+		return o, 0, fmt.Errorf("expected indexed Document or list got %v", o.typ)
+	}
+	n, l := binary.Uvarint(b[1:])
+	if l <= 0 || len(b) < 1+l+4 {
+		return o, 0, errIndexedCorrupt
+	}
+	rest := b[1+l+4:]
+	size := uint64(binary.LittleEndian.Uint32(b[1+l:]))
+	if size > uint64(len(rest)) || n > size/uint64(entry) {
+		return o, 0, errIndexedCorrupt
+	}
+	o.n = int(n)
+	o.table = rest[:o.n*entry]
+	o.data = rest[o.n*entry : size]
+	return o, 1 + l + 4 + int(size), nil
+}
+
+func (o indexedObject) entrySize() int {
+	switch o.typ {
+	case encodeTypeIndexedDocument:
+		return 8
+	case encodeTypeIndexedList:
+		return 4
+	}
+	return 0
+}
+
+// offset returns the data from the j-th offset of the table up to the next
+// one. Offsets must be strictly increasing, so that a corrupt table cannot
+// refer to the same value many times over.
+func (o indexedObject) offset(j int) ([]byte, error) {
+	off := uint64(binary.LittleEndian.Uint32(o.table[4*j:]))
+	end := uint64(len(o.data))
+	if next := 4 * (j + 1); next < len(o.table) {
+		end = uint64(binary.LittleEndian.Uint32(o.table[next:]))
+	}
+	if off >= end || end > uint64(len(o.data)) {
+		return nil, errIndexedCorrupt
+	}
+	return o.data[off:end], nil
+}
+
+// key returns the i-th key of a Document, without copying it
+func (o indexedObject) key(i int) ([]byte, error) {
+	b, err := o.offset(2 * i)
+	if err != nil {
+		return nil, err
+	}
+	length, l := binary.Uvarint(b)
+	if l <= 0 || length > uint64(len(b)-l) {
+		return nil, errIndexedCorrupt
+	}
+	return b[l : l+int(length)], nil
+}
+
+// value returns the encoding of the i-th value
+func (o indexedObject) value(i int) ([]byte, error) {
+	if o.typ == encodeTypeIndexedDocument {
+		return o.offset(2*i + 1)
+	}
+	return o.offset(i)
+}
+
+// find returns the index of key in a Document, using a binary search as
+// keys are sorted
+func (o indexedObject) find(key string) (int, bool) {
+	want := []byte(key)
+	lo, hi := 0, o.n
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		k, err := o.key(mid)
+		if err != nil {
+			return 0, false
+		}
+		switch c := bytes.Compare(k, want); {
+		case c == 0:
+			return mid, true
+		case c < 0:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return 0, false
+}
+
+// indexedDecoder decodes values of the indexed layout
+type indexedDecoder struct {
+	limits   DecodeOptions
+	depth    int
+	elements int
+}
+
+func (d *indexedDecoder) decodeValue(b []byte) (interface{}, error) {
+	if len(b) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	switch typ := encodeType(b[0]); typ {
+	case encodeTypeIndexedDocument, encodeTypeIndexedList:
+		o, _, err := parseIndexedObject(b)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeObject(o)
+	case encodeTypeString, encodeTypeNumber:
+		if len(b) < 9 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		length := binary.LittleEndian.Uint64(b[1:])
+		if length > uint64(d.limits.MaxStringLength) {
+			return nil, &LimitError{Limit: "MaxStringLength", Max: int64(d.limits.MaxStringLength)}
+		}
+		if length > uint64(len(b)-9) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		s := string(b[9 : 9+length])
+		if typ == encodeTypeNumber {
+			return json.Number(s), nil
+		}
+		return s, nil
+	case encodeTypeBool:
+		if len(b) < 2 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return b[1] == 1, nil
+	case encodeTypeFloat64:
+		if len(b) < 9 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b[1:])), nil
+	case encodeTypeInt:
+		num, l := binary.Varint(b[1:])
+		if l <= 0 {
+			return nil, errIndexedCorrupt
+		}
+		return float64(num), nil
+	case encodeTypeNil:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("decoding not supported for type %v", typ)
+	}
+}
+
+func (d *indexedDecoder) decodeObject(o indexedObject) (interface{}, error) {
+	d.depth++
+	defer func() { d.depth-- }()
+	if d.depth > d.limits.MaxDepth {
+		return nil, &LimitError{Limit: "MaxDepth", Max: int64(d.limits.MaxDepth)}
+	}
+	d.elements += o.n
+	if d.elements > d.limits.MaxElements {
+		return nil, &LimitError{Limit: "MaxElements", Max: int64(d.limits.MaxElements)}
+	}
+
+	if o.typ == encodeTypeIndexedList {
+		list := make([]interface{}, o.n)
+		for i := range list {
+			b, err := o.value(i)
+			if err != nil {
+				return nil, err
+			}
+			if list[i], err = d.decodeValue(b); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+
+	doc := make(Document, o.n)
+	for i := 0; i < o.n; i++ {
+		key, err := o.key(i)
+		if err != nil {
+			return nil, err
+		}
+		b, err := o.value(i)
+		if err != nil {
+			return nil, err
+		}
+		if doc[string(key)], err = d.decodeValue(b); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// LazyDocument reads the fields of a payload written with the indexed layout
+// on demand, without decoding the rest of the Document. It is backed by the
+// payload, which must not be modified while it is in use.
+//
+// Payloads with a checksum are verified when the LazyDocument is created,
+// other payloads are only checked as they are read and corrupt fields are
+// reported as missing.
+type LazyDocument struct {
+	obj    indexedObject
+	limits DecodeOptions
+}
+
+// NewLazyDocument returns a LazyDocument for a payload written with
+// EncodeOptions.Indexed, using the default limits of DecodeOptions
+func NewLazyDocument(data []byte) (*LazyDocument, error) {
+	return DecodeOptions{}.NewLazyDocument(data)
+}
+
+// NewLazyDocument returns a LazyDocument for a payload written with
+// EncodeOptions.Indexed. Payloads compressed with a Codec other than
+// CodecNone are decompressed, others are read in place.
+func (o DecodeOptions) NewLazyDocument(data []byte) (*LazyDocument, error) {
+	if !hasBinaryHeader(data) {
+		return nil, errors.New("binary payload is not indexed, it is written with EncodeOptions.Indexed")
+	}
+	h, body, want, err := payloadBody(data)
+	if err != nil {
+		return nil, err
+	}
+	if h.flags&binaryFlagIndexed == 0 {
+		return nil, errors.New("binary payload is not indexed, it is written with EncodeOptions.Indexed")
+	}
+	return o.lazyDocument(h, body, want)
+}
+
+func (o DecodeOptions) lazyDocument(h binaryHeader, body []byte, want uint32) (*LazyDocument, error) {
+	o = o.withDefaults()
+	body, err := o.readBody(h, body, want)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, n, err := parseIndexedObject(body)
+	if err != nil {
+		return nil, decodeError(err)
+	}
+	if obj.typ != encodeTypeIndexedDocument {
+		return nil, fmt.Errorf("%w: expected Document got %v", ErrCorrupt, obj.typ)
+	}
+	if n != len(body) {
+		return nil, fmt.Errorf("%w: trailing data after Document", ErrCorrupt)
+	}
+	return &LazyDocument{obj: obj, limits: o}, nil
+}
+
+// Len returns the number of keys of the Document
+func (l *LazyDocument) Len() int {
+	return l.obj.n
+}
+
+// Keys returns the keys of the Document, in sorted order
+func (l *LazyDocument) Keys() []string {
+	keys := make([]string, 0, l.obj.n)
+	for i := 0; i < l.obj.n; i++ {
+		if key, err := l.obj.key(i); err == nil {
+			keys = append(keys, string(key))
+		}
+	}
+	return keys
+}
+
+// GetPath returns the value at the path, in the same way as Document.GetPath,
+// only decoding that value
+func (l *LazyDocument) GetPath(parts ...string) (interface{}, bool) {
+	if len(parts) == 0 {
+		return "", false
+	}
+	b, ok := lazyFind(l.obj, parts)
+	if !ok {
+		return nil, false
+	}
+	d := indexedDecoder{limits: l.limits}
+	val, err := d.decodeValue(b)
+	if err != nil {
+		return nil, false
+	}
+	return val, true
+}
+
+// GetLazy returns the Document at the path as a LazyDocument, without
+// decoding it
+func (l *LazyDocument) GetLazy(parts ...string) (*LazyDocument, bool) {
+	if len(parts) == 0 {
+		return l, true
+	}
+	b, ok := lazyFind(l.obj, parts)
+	if !ok {
+		return nil, false
+	}
+	obj, _, err := parseIndexedObject(b)
+	if err != nil || obj.typ != encodeTypeIndexedDocument {
+		return nil, false
+	}
+	return &LazyDocument{obj: obj, limits: l.limits}, true
+}
+
+// Document decodes the whole Document
+func (l *LazyDocument) Document() (Document, error) {
+	d := indexedDecoder{limits: l.limits}
+	val, err := d.decodeObject(l.obj)
+	if err != nil {
+		return nil, decodeError(err)
+	}
+	return val.(Document), nil
+}
+
+// lazyFind returns the encoding of the value at the path
+func lazyFind(o indexedObject, parts []string) ([]byte, bool) {
+	seg, rest := parts[0], parts[1:]
+	find := func(i int) ([]byte, bool) {
+		b, err := o.value(i)
+		if err != nil {
+			return nil, false
+		}
+		if len(rest) == 0 {
+			return b, true
+		}
+		child, _, err := parseIndexedObject(b)
+		if err != nil {
+			return nil, false
+		}
+		return lazyFind(child, rest)
+	}
+
+	if seg == PathWildcard {
+		for i := 0; i < o.n; i++ {
+			if b, ok := find(i); ok {
+				return b, true
+			}
+		}
+		return nil, false
+	}
+	var (
+		i  int
+		ok bool
+	)
+	if o.typ == encodeTypeIndexedDocument {
+		i, ok = o.find(seg)
+	} else {
+		i, ok = pathIndex(seg, o.n)
+	}
+	if !ok {
+		return nil, false
+	}
+	return find(i)
+}
@@ -0,0 +1,137 @@
+package apidoc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestIndexedRoundTrip(t *testing.T) {
+	doc := loadDeparture(t)
+	doc["number"] = json.Number("12.50")
+	for _, o := range []EncodeOptions{
+		{Indexed: true},
+		{Indexed: true, Checksum: true, Codec: CodecNone},
+		{Indexed: true, Checksum: true, Codec: gzipCodec{}},
+	} {
+		data, err := o.Marshal(doc)
+		ok(t, err)
+		var doc2 Document
+		ok(t, doc2.UnmarshalBinary(data))
+		assert(t, doc.Equal(doc2), "departure should round trip with %+v", o)
+
+		lazy, err := NewLazyDocument(data)
+		ok(t, err)
+		doc3, err := lazy.Document()
+		ok(t, err)
+		assert(t, doc.Equal(doc3), "lazy departure should round trip with %+v", o)
+	}
+}
+
+func TestLazyDocument(t *testing.T) {
+	doc := loadDeparture(t)
+	data, err := EncodeOptions{Indexed: true, Checksum: true, Codec: CodecNone}.Marshal(doc)
+	ok(t, err)
+	lazy, err := NewLazyDocument(data)
+	ok(t, err)
+	equals(t, len(doc), lazy.Len())
+	equals(t, doc.KeysSorted(), lazy.Keys())
+
+	for _, path := range []string{
+		"id",
+		"rooms[0].price_bands[-1].prices[*].currency",
+		"rooms[0].availability",
+		"rooms[0].flags",
+		"start_address.postal_zip",
+		"lowest_pp2a_prices[7].amount",
+		"lowest_pp2a_prices[8].amount",
+		"nope",
+		"id.nope",
+		"rooms.nope",
+	} {
+		p, err := ParsePath(path)
+		ok(t, err)
+		exp, expOk := doc.GetPath(p...)
+		got, gotOk := lazy.GetPath(p...)
+		equals(t, expOk, gotOk)
+		equals(t, exp, got)
+	}
+	_, found := lazy.GetPath()
+	assert(t, !found, "empty path should not be found")
+
+	addr, found := lazy.GetLazy("start_address")
+	assert(t, found, "start_address should be found")
+	equals(t, []string{"city", "country", "latitude", "longitude", "postal_zip", "street"}, addr.Keys())
+	name, found := addr.GetPath("country", "name")
+	assert(t, found, "country name should be found")
+	equals(t, "Zimbabwe", name)
+	_, found = lazy.GetLazy("rooms")
+	assert(t, !found, "a list is not a LazyDocument")
+}
+
+func TestLazyDocumentErrors(t *testing.T) {
+	doc := loadDeparture(t)
+	data, err := doc.MarshalBinary()
+	ok(t, err)
+	_, err = NewLazyDocument(data)
+	assert(t, err != nil, "expected error for a payload that is not indexed")
+
+	_, err = EncodeOptions{Indexed: true, KeyTable: true}.Marshal(doc)
+	assert(t, err != nil, "expected error combining the indexed layout with a key table")
+	assert(t, EncodeOptions{Indexed: true}.NewEncoder(new(bytes.Buffer)).Encode(doc) != nil, "expected error streaming the indexed layout")
+
+	data, err = EncodeOptions{Indexed: true, Checksum: true, Codec: CodecNone}.Marshal(doc)
+	ok(t, err)
+	_, err = NewTokenReader(data)
+	assert(t, err != nil, "expected error reading tokens of an indexed payload")
+	bad := append([]byte{}, data...)
+	bad[bytes.Index(bad, []byte("Zimbabwe"))] ^= 0xFF
+	_, err = NewLazyDocument(bad)
+	assert(t, errors.Is(err, ErrCorrupt), "expected ErrCorrupt got %v", err)
+
+	// every item of each list refers to the same nested list
+	value := []byte{byte(encodeTypeNil)}
+	for i := 0; i < 4; i++ {
+		buf := new(bytes.Buffer)
+		ok(t, writeIndexedObject(buf, encodeTypeIndexedList, 1000, make([]byte, 4*1000), value))
+		value = buf.Bytes()
+	}
+	bomb := new(bytes.Buffer)
+	ok(t, writeIndexedObject(bomb, encodeTypeIndexedDocument, 1, []byte{0, 0, 0, 0, 2, 0, 0, 0}, append([]byte{1, 'a'}, value...)))
+	h := binaryHeader{version: binaryVersion, codec: CodecNone, flags: binaryFlagIndexed}
+	payload := append(h.appendTo(nil), bomb.Bytes()...)
+	for _, o := range []DecodeOptions{{}, {MaxBytes: 1 << 20, MaxElements: -1}} {
+		err = o.Unmarshal(payload, &doc)
+		assert(t, errors.Is(err, ErrCorrupt), "expected ErrCorrupt got %v", err)
+	}
+	lazy, err := NewLazyDocument(payload)
+	ok(t, err)
+	_, found := lazy.GetPath("a")
+	assert(t, !found, "aliased list should not be decoded")
+
+	// offsets that go back or past the data
+	for _, table := range [][]byte{{2, 0, 0, 0, 0, 0, 0, 0}, {0, 0, 0, 0, 9, 0, 0, 0}} {
+		buf := new(bytes.Buffer)
+		ok(t, writeIndexedObject(buf, encodeTypeIndexedDocument, 1, table, []byte{1, 'a', byte(encodeTypeNil)}))
+		err = doc.UnmarshalBinary(append(h.appendTo(nil), buf.Bytes()...))
+		assert(t, errors.Is(err, ErrCorrupt), "expected ErrCorrupt got %v", err)
+	}
+}
+
+func BenchmarkLazyDocumentGetPath(b *testing.B) {
+	data, err := EncodeOptions{Indexed: true, Codec: CodecNone}.Marshal(sampleLargeDoc)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lazy, err := NewLazyDocument(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, ok := lazy.GetPath("foo", "children", "-1", "name"); !ok {
+			b.Fatal("path not found")
+		}
+	}
+}
@@ -4,11 +4,65 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
+	"strconv"
 )
 
+// JSONOptions controls how Documents are decoded from and encoded to JSON
+type JSONOptions struct {
+	// UseNumber decodes JSON numbers as json.Number instead of float64,
+	// keeping their exact text, e.g. large integer IDs or prices like
+	// "1249.00", through JSON, binary encoding and ETag calculation
+	UseNumber bool
+	// Canonical writes JSON as specified by RFC 8785, giving a byte exact
+	// representation which can be signed or hashed. json.Number values are
+	// written as the float64 they parse to.
+	Canonical bool
+}
+
+// WriteOutJSON writes the Document to w as JSON
+func (o JSONOptions) WriteOutJSON(w io.Writer, d Document) error {
+	if !o.Canonical {
+		return d.WriteOutJSON(w)
+	}
+	bw := bufio.NewWriter(w)
+	if err := jcsMarshalDocument(bw, d); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Marshal returns the Document as JSON
+func (o JSONOptions) Marshal(d Document) ([]byte, error) {
+	var buf bytes.Buffer
+	err := o.WriteOutJSON(&buf, d)
+	return buf.Bytes(), err
+}
+
+// Unmarshal decodes the JSON object in data into the Document
+func (o JSONOptions) Unmarshal(data []byte, d *Document) error {
+	if !o.UseNumber {
+		return d.UnmarshalJSON(data)
+	}
+	var root map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&root); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("invalid data after top-level JSON object")
+	}
+	rd, err := jsonUnpackObject(root)
+	if err == nil {
+		*d = rd
+	}
+	return err
+}
+
 // UnmarshalJSON implements json unmarshaling of Document
 func (d *Document) UnmarshalJSON(data []byte) error {
 	// we need to be in charge of unmarshaling to retain some sanity
@@ -17,71 +71,76 @@ func (d *Document) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, &root); err != nil {
 		return err
 	}
-	/*
-		To unmarshal JSON into an interface value, Unmarshal stores one of these in the interface value:
-		bool, for JSON booleans
-		float64, for JSON numbers
-		string, for JSON strings
-		[]interface{}, for JSON arrays
-		map[string]interface{}, for JSON objects
-		nil for JSON null
-	*/
-	var unpackObject func(map[string]interface{}) (Document, error)
-	var unpackValue func(v interface{}) (interface{}, error)
-	unpackList := func(l []interface{}) ([]interface{}, error) {
-		list := make([]interface{}, len(l))
-		for i, v := range l {
-			uv, err := unpackValue(v)
-			if err != nil {
-				return list, err
-			}
-			list[i] = uv
-		}
-		return list, nil
+	// ok do the root
+	rd, rerr := jsonUnpackObject(root)
+	if rerr == nil {
+		*d = rd
 	}
-	unpackValue = func(v interface{}) (interface{}, error) {
-		switch vv := v.(type) {
-		case bool:
-			return vv, nil
-		case float64:
-			return vv, nil
-		case string:
-			return vv, nil
-		case []interface{}:
-			u, err := unpackList(vv)
-			if err != nil {
-				return nil, err
-			}
-			return u, nil
-		case map[string]interface{}:
-			u, err := unpackObject(vv)
-			if err != nil {
-				return nil, err
-			}
-			return u, nil
-		case nil:
-			return nil, nil
-		default:
-			return nil, fmt.Errorf("illegal type %T", v)
+	return rerr
+}
+
+// jsonUnpackValue converts the output of json.Unmarshal into an interface{}
+// to the values supported in a Document. To unmarshal JSON into an interface
+// value, Unmarshal stores one of these in the interface value:
+//
+//	bool, for JSON booleans
+//	float64, for JSON numbers
+//	json.Number, for JSON numbers when using json.Decoder.UseNumber
+//	string, for JSON strings
+//	[]interface{}, for JSON arrays
+//	map[string]interface{}, for JSON objects, which become Documents
+//	nil for JSON null
+func jsonUnpackValue(v interface{}) (interface{}, error) {
+	switch vv := v.(type) {
+	case bool:
+		return vv, nil
+	case float64:
+		return vv, nil
+	case json.Number:
+		return vv, nil
+	case string:
+		return vv, nil
+	case []interface{}:
+		u, err := jsonUnpackList(vv)
+		if err != nil {
+			return nil, err
+		}
+		return u, nil
+	case map[string]interface{}:
+		u, err := jsonUnpackObject(vv)
+		if err != nil {
+			return nil, err
 		}
+		return u, nil
+	case nil:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("illegal type %T", v)
 	}
-	unpackObject = func(rawObj map[string]interface{}) (Document, error) {
-		d := make(Document)
-		for k, v := range rawObj {
-			uv, err := unpackValue(v)
-			if err != nil {
-				return d, err
-			}
-			d[k] = uv
+}
+
+func jsonUnpackList(l []interface{}) ([]interface{}, error) {
+	list := make([]interface{}, len(l))
+	for i, v := range l {
+		uv, err := jsonUnpackValue(v)
+		if err != nil {
+			return list, err
 		}
-		return d, nil
+		list[i] = uv
 	}
-	// ok do the root
-	rd, rerr := unpackObject(root)
-	if rerr == nil {
-		*d = rd
+	return list, nil
+}
+
+func jsonUnpackObject(rawObj map[string]interface{}) (Document, error) {
+	d := make(Document)
+	for k, v := range rawObj {
+		uv, err := jsonUnpackValue(v)
+		if err != nil {
+			return d, err
+		}
+		d[k] = uv
 	}
-	return rerr
+	return d, nil
 }
 
 // MarshalJSON implements json marshaling of Document
@@ -107,6 +166,7 @@ func (d Document) WriteOutJSON(w io.Writer) error {
 // ==============|==============
 // bool          | JSON booleans
 // float64       | JSON numbers
+// json.Number   | JSON numbers
 // string        | JSON strings
 // []interface{} | JSON arrays
 // Document      | JSON objects
@@ -147,6 +207,8 @@ func jsonMarshalDocument(w *bufio.Writer, doc Document, flush bool) error {
 			err = jsonMarshalString(w, val)
 		case float64:
 			err = jsonMarshalFloat64(w, val)
+		case json.Number:
+			err = jsonMarshalNumber(w, val)
 		case bool:
 			err = jsonMarshalBool(w, val)
 		case Document:
@@ -211,14 +273,93 @@ func jsonMarshalString(w *bufio.Writer, s string) error {
 }
 
 func jsonMarshalFloat64(w *bufio.Writer, n float64) error {
-	if math.Ceil(n) == n {
-		// integer
-		fmt.Fprintf(w, "%.f", n)
-	} else {
-		// float
-		fmt.Fprintf(w, "%f", n)
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return fmt.Errorf("unsupported number %v", n)
 	}
-	return nil
+	var scratch [32]byte
+	_, err := w.Write(appendFloat64(scratch[:0], n))
+	return err
+}
+
+// appendFloat64 formats n with the shortest representation that parses back
+// to the same float64, using exponents only for very small or large numbers
+// in the same way as encoding/json
+func appendFloat64(b []byte, n float64) []byte {
+	format := byte('f')
+	if abs := math.Abs(n); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b = strconv.AppendFloat(b, n, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		l := len(b)
+		if l >= 4 && b[l-4] == 'e' && b[l-3] == '-' && b[l-2] == '0' {
+			b[l-2] = b[l-1]
+			b = b[:l-1]
+		}
+	}
+	return b
+}
+
+// jsonMarshalNumber writes the text of the number as is, the empty
+// json.Number is written as 0
+func jsonMarshalNumber(w *bufio.Writer, n json.Number) error {
+	// the same as encoding/json
+	if n == "" {
+		n = "0"
+	}
+	if !isValidNumber(string(n)) {
+		return fmt.Errorf("invalid number %q", string(n))
+	}
+	_, err := w.WriteString(string(n))
+	return err
+}
+
+// isValidNumber reports if s is a JSON number, as defined by RFC 8259
+// section 6
+func isValidNumber(s string) bool {
+	if s != "" && s[0] == '-' {
+		s = s[1:]
+	}
+	if s == "" {
+		return false
+	}
+
+	// integer part, without leading zeros
+	switch {
+	case s[0] == '0':
+		s = s[1:]
+	case '1' <= s[0] && s[0] <= '9':
+		s = s[1:]
+		for s != "" && '0' <= s[0] && s[0] <= '9' {
+			s = s[1:]
+		}
+	default:
+		return false
+	}
+
+	// fraction
+	if len(s) >= 2 && s[0] == '.' && '0' <= s[1] && s[1] <= '9' {
+		s = s[2:]
+		for s != "" && '0' <= s[0] && s[0] <= '9' {
+			s = s[1:]
+		}
+	}
+
+	// exponent
+	if len(s) >= 2 && (s[0] == 'e' || s[0] == 'E') {
+		s = s[1:]
+		if s[0] == '+' || s[0] == '-' {
+			s = s[1:]
+			if s == "" {
+				return false
+			}
+		}
+		for s != "" && '0' <= s[0] && s[0] <= '9' {
+			s = s[1:]
+		}
+	}
+	return s == ""
 }
 
 func jsonMarshalBool(w *bufio.Writer, b bool) error {
@@ -250,6 +391,8 @@ func jsonMarshalList(w *bufio.Writer, list []interface{}) error {
 			err = jsonMarshalString(w, val)
 		case float64:
 			err = jsonMarshalFloat64(w, val)
+		case json.Number:
+			err = jsonMarshalNumber(w, val)
 		case bool:
 			err = jsonMarshalBool(w, val)
 		case Document:
@@ -274,6 +417,28 @@ func jsonMarshalList(w *bufio.Writer, list []interface{}) error {
 	return err
 }
 
+// jsonMarshalValue marshals any of the values supported in a Document
+func jsonMarshalValue(w *bufio.Writer, val interface{}) error {
+	switch val := val.(type) {
+	case string:
+		return jsonMarshalString(w, val)
+	case float64:
+		return jsonMarshalFloat64(w, val)
+	case json.Number:
+		return jsonMarshalNumber(w, val)
+	case bool:
+		return jsonMarshalBool(w, val)
+	case Document:
+		return jsonMarshalDocument(w, val, false)
+	case []interface{}:
+		return jsonMarshalList(w, val)
+	case nil:
+		return jsonMarshalNil(w)
+	default:
+		return fmt.Errorf("unexpected type %T for value %v", val, val)
+	}
+}
+
 func jsonMarshalNil(w *bufio.Writer) error {
 	_, err := w.WriteString("null")
 	return err
@@ -0,0 +1,279 @@
+package apidoc
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// MergePatch applies an RFC 7386 JSON Merge Patch to the document in place.
+// Null values in the patch delete the key, nested Documents are merged
+// recursively and any other value, lists included, replaces the existing
+// value.
+//
+// The same as a map assignment, d must not be nil when the patch sets any
+// key or MergePatch panics. Nested nil Documents are replaced.
+//
+// See: https://www.rfc-editor.org/rfc/rfc7386
+func (d Document) MergePatch(patch Document) {
+	for k, v := range patch {
+		switch pv := v.(type) {
+		case nil:
+			delete(d, k)
+		case Document:
+			target, ok := d[k].(Document)
+			if !ok || target == nil {
+				target = New()
+			}
+			target.MergePatch(pv)
+			d[k] = target
+		default:
+			d[k] = copyValue(v)
+		}
+	}
+}
+
+// CreateMergePatch returns the RFC 7386 JSON Merge Patch that turns orig into
+// updated when applied with MergePatch.
+//
+// As null means delete in a merge patch, null values in updated can only be
+// represented if orig already has a non-null value for that key, and they
+// will then be deleted rather than set to null.
+func CreateMergePatch(orig, updated Document) Document {
+	patch := New()
+	for k := range orig {
+		if _, prs := updated[k]; !prs {
+			patch[k] = nil
+		}
+	}
+	for k, uv := range updated {
+		ov, prs := orig[k]
+		if !prs {
+			if uv != nil {
+				patch[k] = copyValue(uv)
+			}
+			continue
+		}
+		od, origIsDoc := ov.(Document)
+		ud, updatedIsDoc := uv.(Document)
+		if origIsDoc && updatedIsDoc {
+			if sub := CreateMergePatch(od, ud); len(sub) > 0 {
+				patch[k] = sub
+			}
+			continue
+		}
+		if !equalValues(ov, uv) {
+			patch[k] = copyValue(uv)
+		}
+	}
+	return patch
+}
+
+// MergeOptions controls how Merge3 combines lists
+type MergeOptions struct {
+	// ListKeys are identity keys, such as "id" or "code", used to merge lists
+	// of Documents item by item. The first key with a unique value in every
+	// item of the base, ours and theirs lists is used. Lists that cannot be
+	// keyed are merged as atomic values.
+	ListKeys []string
+}
+
+// Conflict is a path changed differently on both sides of a three-way merge.
+// Values missing from a side are nil with the matching Deleted flag set.
+type Conflict struct {
+	Path          Path
+	Base          interface{}
+	Ours          interface{}
+	Theirs        interface{}
+	OursDeleted   bool
+	TheirsDeleted bool
+}
+
+// String renders the conflict in a human readable form, e.g.
+//
+//	rooms[0].availability.total: ours 3, theirs 4 (base 5)
+func (c Conflict) String() string {
+	side := func(v interface{}, deleted bool) string {
+		if deleted {
+			return "deleted"
+		}
+		return formatValue(v)
+	}
+	return fmt.Sprintf("%s: ours %s, theirs %s (base %s)",
+		c.Path, side(c.Ours, c.OursDeleted), side(c.Theirs, c.TheirsDeleted), formatValue(c.Base))
+}
+
+// Merge3 performs a three-way merge of the changes made by ours and theirs
+// to their common ancestor base, treating lists as atomic values. See
+// MergeOptions.Merge3.
+func Merge3(base, ours, theirs Document) (Document, []Conflict) {
+	return MergeOptions{}.Merge3(base, ours, theirs)
+}
+
+// Merge3 performs a three-way merge of the changes made by ours and theirs
+// to their common ancestor base. A value changed on one side only takes that
+// change, a value changed identically on both sides is merged cleanly and
+// nested Documents are merged key by key.
+//
+// Values changed differently on both sides are reported as conflicts, in
+// the same path notation used by GetPath, and resolved in favour of ours.
+// None of the input Documents are modified.
+func (o MergeOptions) Merge3(base, ours, theirs Document) (Document, []Conflict) {
+	m := merger{opts: o}
+	doc := m.mergeDocuments(nil, base, ours, theirs)
+	return doc, m.conflicts
+}
+
+// mergeValue is a value which may be missing from one side of the merge
+type mergeValue struct {
+	val interface{}
+	prs bool
+}
+
+func (v mergeValue) equal(other mergeValue) bool {
+	return v.prs == other.prs && (!v.prs || equalValues(v.val, other.val))
+}
+
+type merger struct {
+	opts      MergeOptions
+	conflicts []Conflict
+}
+
+func (m *merger) merge(path Path, base, ours, theirs mergeValue) mergeValue {
+	switch {
+	case ours.equal(theirs), base.equal(theirs):
+		return mergeValue{val: copyValue(ours.val), prs: ours.prs}
+	case base.equal(ours):
+		return mergeValue{val: copyValue(theirs.val), prs: theirs.prs}
+	}
+
+	switch ov := ours.val.(type) {
+	case Document:
+		if tv, ok := theirs.val.(Document); ok {
+			bv, _ := base.val.(Document)
+			return mergeValue{val: m.mergeDocuments(path, bv, ov, tv), prs: true}
+		}
+	case []interface{}:
+		if tv, ok := theirs.val.([]interface{}); ok {
+			bv, _ := base.val.([]interface{})
+			if key, ok := m.listKey(bv, ov, tv); ok {
+				return mergeValue{val: m.mergeLists(path, key, bv, ov, tv), prs: true}
+			}
+		}
+	}
+
+	m.conflicts = append(m.conflicts, Conflict{
+		Path:          path,
+		Base:          base.val,
+		Ours:          ours.val,
+		Theirs:        theirs.val,
+		OursDeleted:   !ours.prs,
+		TheirsDeleted: !theirs.prs,
+	})
+	return mergeValue{val: copyValue(ours.val), prs: ours.prs}
+}
+
+func (m *merger) mergeDocuments(path Path, base, ours, theirs Document) Document {
+	keys := make(map[string]struct{}, len(ours))
+	for _, doc := range []Document{base, ours, theirs} {
+		for k := range doc {
+			keys[k] = struct{}{}
+		}
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	doc := make(Document, len(ours))
+	for _, k := range sorted {
+		bv, bprs := base[k]
+		ov, oprs := ours[k]
+		tv, tprs := theirs[k]
+		res := m.merge(appendPath(path, k),
+			mergeValue{bv, bprs}, mergeValue{ov, oprs}, mergeValue{tv, tprs})
+		if res.prs {
+			doc[k] = res.val
+		}
+	}
+	return doc
+}
+
+// listKey returns the first of the ListKeys which identifies every item in
+// the lists
+func (m *merger) listKey(lists ...[]interface{}) (string, bool) {
+Keys:
+	for _, key := range m.opts.ListKeys {
+		for _, list := range lists {
+			seen := make(map[string]struct{}, len(list))
+			for _, item := range list {
+				id, ok := listItemID(item, key)
+				if !ok {
+					continue Keys
+				}
+				if _, dup := seen[id]; dup {
+					continue Keys
+				}
+				seen[id] = struct{}{}
+			}
+		}
+		return key, true
+	}
+	return "", false
+}
+
+// listItemID returns the identity of a list item under key
+func listItemID(item interface{}, key string) (string, bool) {
+	doc, ok := item.(Document)
+	if !ok {
+		return "", false
+	}
+	switch id := doc[key].(type) {
+	case string, float64, json.Number:
+		return formatValue(id), true
+	default:
+		return "", false
+	}
+}
+
+// mergeLists merges lists of Documents by identity key. The result keeps the
+// order of ours, followed by the items only added by theirs.
+func (m *merger) mergeLists(path Path, key string, base, ours, theirs []interface{}) []interface{} {
+	index := func(list []interface{}) map[string]interface{} {
+		items := make(map[string]interface{}, len(list))
+		for _, item := range list {
+			id, _ := listItemID(item, key)
+			items[id] = item
+		}
+		return items
+	}
+	bm, om, tm := index(base), index(ours), index(theirs)
+
+	// ours, then new in theirs, then deleted by ours but still in base
+	var ids []string
+	seen := make(map[string]struct{}, len(ours))
+	for _, l := range [][]interface{}{ours, theirs, base} {
+		for _, item := range l {
+			id, _ := listItemID(item, key)
+			if _, dup := seen[id]; !dup {
+				seen[id] = struct{}{}
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	list := make([]interface{}, 0, len(ours))
+	for _, id := range ids {
+		bv, bprs := bm[id]
+		ov, oprs := om[id]
+		tv, tprs := tm[id]
+		res := m.merge(appendPath(path, strconv.Itoa(len(list))),
+			mergeValue{bv, bprs}, mergeValue{ov, oprs}, mergeValue{tv, tprs})
+		if res.prs {
+			list = append(list, res.val)
+		}
+	}
+	return list
+}
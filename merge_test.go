@@ -0,0 +1,188 @@
+package apidoc
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// examples from RFC 7386 Appendix A which have an object as the target and
+// the patch
+func TestMergePatchRFC7386(t *testing.T) {
+	cases := []struct {
+		target, patch, exp string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, c := range cases {
+		doc := mustDocument(t, c.target)
+		doc.MergePatch(mustDocument(t, c.patch))
+		equals(t, mustDocument(t, c.exp), doc)
+	}
+}
+
+func TestMergePatchDeparture(t *testing.T) {
+	doc := loadDeparture(t)
+	patch := mustDocument(t, `{
+		"name": "Delta & Falls Overland (Eastbound)",
+		"start_address": {"postal_zip": null, "country": {"name": "Zimbabwe (ZW)"}},
+		"flags": ["FULL"],
+		"components": null
+	}`)
+	doc.MergePatch(patch)
+
+	equals(t, "Delta & Falls Overland (Eastbound)", doc["name"])
+	val, _ := doc.GetPath("start_address", "country", "name")
+	equals(t, "Zimbabwe (ZW)", val)
+	val, _ = doc.GetPath("start_address", "country", "id")
+	equals(t, "ZW", val)
+	_, found := doc.GetPath("start_address", "postal_zip")
+	assert(t, !found, "postal_zip should be deleted")
+	_, found = doc["components"]
+	assert(t, !found, "components should be deleted")
+	equals(t, []interface{}{"FULL"}, doc["flags"])
+
+	// patch values are copied into the document
+	patch["flags"].([]interface{})[0] = "CHANGED"
+	equals(t, []interface{}{"FULL"}, doc["flags"])
+}
+
+func TestMergePatchNil(t *testing.T) {
+	doc := Document{"a": Document(nil)}
+	doc.MergePatch(Document{"a": Document{"b": "c"}})
+	equals(t, Document{"a": Document{"b": "c"}}, doc)
+
+	// a nil Document can only have keys deleted
+	var nilDoc Document
+	nilDoc.MergePatch(Document{"a": nil})
+	defer func() {
+		assert(t, recover() != nil, "expected a panic setting a key of a nil Document")
+	}()
+	nilDoc.MergePatch(Document{"a": "b"})
+}
+
+func TestCreateMergePatch(t *testing.T) {
+	orig := loadDeparture(t)
+	updated := *orig.Copy()
+	ok(t, updated.SetPath(3.0, "rooms", "0", "availability", "total"))
+	ok(t, updated.SetPath("Windhoek Central", "finish_address", "city"))
+	ok(t, updated.DeletePath("finish_address", "postal_zip"))
+	ok(t, updated.DeletePath("tour_dossier"))
+	ok(t, updated.SetPath(Document{"code": "SPRING"}, "promotion"))
+
+	patch := CreateMergePatch(orig, updated)
+	equals(t, mustDocument(t, `{
+		"rooms": [`+formatValue(updated["rooms"].([]interface{})[0])+`],
+		"finish_address": {"city": "Windhoek Central", "postal_zip": null},
+		"tour_dossier": null,
+		"promotion": {"code": "SPRING"}
+	}`), patch)
+
+	patched := *orig.Copy()
+	patched.MergePatch(patch)
+	assert(t, patched.Equal(updated), "patched document should equal updated")
+
+	equals(t, Document{}, CreateMergePatch(orig, orig))
+}
+
+func TestMerge3(t *testing.T) {
+	base := loadDeparture(t)
+	ours := *base.Copy()
+	theirs := *base.Copy()
+
+	// non overlapping changes
+	ok(t, ours.SetPath("Windhoek Central", "finish_address", "city"))
+	ok(t, theirs.SetPath(4.0, "rooms", "0", "availability", "total"))
+	ok(t, theirs.DeletePath("components"))
+	ok(t, ours.SetPath("SPRING", "promotion", "code"))
+	// the same change on both sides
+	ok(t, ours.SetPath("FULL", "flags", "0"))
+	ok(t, theirs.SetPath("FULL", "flags", "0"))
+
+	merged, conflicts := Merge3(base, ours, theirs)
+	equals(t, 0, len(conflicts))
+	expected := *base.Copy()
+	ok(t, expected.SetPath("Windhoek Central", "finish_address", "city"))
+	ok(t, expected.SetPath(4.0, "rooms", "0", "availability", "total"))
+	ok(t, expected.DeletePath("components"))
+	ok(t, expected.SetPath("SPRING", "promotion", "code"))
+	ok(t, expected.SetPath("FULL", "flags", "0"))
+	assert(t, merged.Equal(expected), "unexpected merge result %s", FormatDiff(Diff(expected, merged)))
+
+	// conflicting changes keep ours
+	ok(t, ours.SetPath(3.0, "rooms", "0", "availability", "total"))
+	ok(t, ours.DeletePath("tour"))
+	ok(t, theirs.SetPath("x", "tour", "id"))
+	merged, conflicts = Merge3(base, ours, theirs)
+	equals(t, 2, len(conflicts))
+	equals(t, "rooms", conflicts[0].Path.String())
+	equals(t, "tour", conflicts[1].Path.String())
+	assert(t, conflicts[1].OursDeleted, "ours deleted the tour")
+	equals(t, `tour: ours deleted, theirs {"href":"https://rest.gadventures.com/tours/23185","id":"x"} (base {"href":"https://rest.gadventures.com/tours/23185","id":"23185"})`, conflicts[1].String())
+	equals(t, ours["rooms"], merged["rooms"])
+	_, found := merged["tour"]
+	assert(t, !found, "ours deletion should win")
+
+	// the inputs are left untouched
+	_, found = base.GetPath("promotion")
+	assert(t, !found, "base should not be modified")
+}
+
+func TestMerge3Numbers(t *testing.T) {
+	var base Document
+	ok(t, JSONOptions{UseNumber: true}.Unmarshal([]byte(`{"a":5,"b":1}`), &base))
+	ours := Document{"a": 6.0, "b": json.Number("1.0")}
+	theirs := Document{"a": json.Number("6"), "b": 2.0}
+	merged, conflicts := Merge3(base, ours, theirs)
+	equals(t, 0, len(conflicts))
+	equals(t, Document{"a": 6.0, "b": 2.0}, merged)
+}
+
+func TestMerge3ListKeys(t *testing.T) {
+	base := loadDeparture(t)
+	ours := *base.Copy()
+	theirs := *base.Copy()
+	opts := MergeOptions{ListKeys: []string{"id", "currency", "code"}}
+
+	ok(t, ours.SetPath(3.0, "rooms", "0", "availability", "total"))
+	ok(t, theirs.SetPath("1299.00", "rooms", "0", "price_bands", "0", "prices", "5", "amount"))
+	ok(t, ours.DeletePath("lowest_pp2a_prices", "0"))
+	ok(t, theirs.SetPath(Document{"currency": "JPY", "amount": "150000"}, "lowest_pp2a_prices", "8"))
+	ok(t, theirs.SetPath("1009.50", "lowest_pp2a_prices", "2", "amount"))
+
+	merged, conflicts := opts.Merge3(base, ours, theirs)
+	equals(t, 0, len(conflicts))
+	val, _ := merged.GetPath("rooms", "0", "availability", "total")
+	equals(t, 3.0, val)
+	val, _ = merged.GetPath("rooms", "0", "price_bands", "0", "prices", "5", "amount")
+	equals(t, "1299.00", val)
+	currencies := MustCompileQuery("lowest_pp2a_prices[*].currency").Eval(merged)
+	equals(t, []interface{}{"AUD", "CHF", "GBP", "NZD", "CAD", "ZAR", "EUR", "JPY"}, currencies)
+	val, _ = merged.GetPath("lowest_pp2a_prices", "1", "amount")
+	equals(t, "1009.50", val)
+
+	// same item changed on both sides
+	ok(t, ours.SetPath("1149.00", "lowest_pp2a_prices", "0", "amount"))
+	ok(t, theirs.SetPath("1349.00", "lowest_pp2a_prices", "1", "amount"))
+	merged, conflicts = opts.Merge3(base, ours, theirs)
+	equals(t, 1, len(conflicts))
+	equals(t, "lowest_pp2a_prices[0].amount", conflicts[0].Path.String())
+	equals(t, `lowest_pp2a_prices[0].amount: ours "1149.00", theirs "1349.00" (base "1249.00")`, conflicts[0].String())
+	val, _ = merged.GetPath("lowest_pp2a_prices", "0", "amount")
+	equals(t, "1149.00", val)
+
+	// lists without a usable key are atomic
+	ok(t, ours.SetPath("a", "flags", "0"))
+	ok(t, theirs.SetPath("b", "flags", "0"))
+	_, conflicts = opts.Merge3(base, ours, theirs)
+	equals(t, 2, len(conflicts))
+	equals(t, "flags", conflicts[0].Path.String())
+}
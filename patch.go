@@ -0,0 +1,321 @@
+package apidoc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// the operations defined by RFC 6902
+const (
+	PatchAdd     = "add"
+	PatchRemove  = "remove"
+	PatchReplace = "replace"
+	PatchMove    = "move"
+	PatchCopy    = "copy"
+	PatchTest    = "test"
+)
+
+// errors returned while applying a JSON Patch, wrapped in a *PatchError
+var (
+	ErrInvalidPatch    = errors.New("invalid patch operation")
+	ErrPatchTestFailed = errors.New("patch test failed")
+)
+
+// PatchOp is a single RFC 6902 JSON Patch operation. Path and From are
+// RFC 6901 JSON Pointers.
+//
+// See: https://www.rfc-editor.org/rfc/rfc6902
+type PatchOp struct {
+	Op    string
+	Path  string
+	From  string
+	Value interface{}
+}
+
+// PatchError records the operation that failed while applying a patch
+type PatchError struct {
+	Index int
+	Op    PatchOp
+	Err   error
+}
+
+func (e *PatchError) Error() string {
+	return fmt.Sprintf("patch operation %d (%s %s): %s", e.Index, e.Op.Op, e.Op.Path, e.Err.Error())
+}
+
+// Unwrap returns the underlying error, for use with errors.Is and errors.As
+func (e *PatchError) Unwrap() error {
+	return e.Err
+}
+
+// MarshalJSON implements json marshaling of PatchOp, the value member is
+// only written for the operations that take one
+func (op PatchOp) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	w.WriteString(`{"op":`)
+	jsonMarshalString(w, op.Op)
+	w.WriteString(`,"path":`)
+	jsonMarshalString(w, op.Path)
+	switch op.Op {
+	case PatchMove, PatchCopy:
+		w.WriteString(`,"from":`)
+		jsonMarshalString(w, op.From)
+	case PatchAdd, PatchReplace, PatchTest:
+		w.WriteString(`,"value":`)
+		if err := jsonMarshalValue(w, op.Value); err != nil {
+			return nil, err
+		}
+	}
+	w.WriteRune('}')
+	err := w.Flush()
+	return buf.Bytes(), err
+}
+
+// UnmarshalJSON implements json unmarshaling of PatchOp, the value member is
+// converted to the types documented on Document. The value member is
+// required by the add, replace and test operations, even when it is null.
+func (op *PatchOp) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		From  string          `json:"from"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	var val interface{}
+	if raw.Value != nil {
+		if err := json.Unmarshal(raw.Value, &val); err != nil {
+			return err
+		}
+	} else if raw.Op == PatchAdd || raw.Op == PatchReplace || raw.Op == PatchTest {
+		return fmt.Errorf("%w: %s operation is missing the value member", ErrInvalidPatch, raw.Op)
+	}
+	val, err := jsonUnpackValue(val)
+	if err != nil {
+		return err
+	}
+	*op = PatchOp{Op: raw.Op, Path: raw.Path, From: raw.From, Value: val}
+	return nil
+}
+
+// ApplyPatch applies the RFC 6902 JSON Patch operations in order. The patch
+// is applied atomically, if any operation fails the Document is left
+// unchanged and a *PatchError is returned.
+func (d *Document) ApplyPatch(ops []PatchOp) error {
+	var root interface{} = *d.Copy()
+	for idx, op := range ops {
+		var err error
+		root, err = applyPatchOp(root, op)
+		if err != nil {
+			return &PatchError{Index: idx, Op: op, Err: err}
+		}
+	}
+	*d = root.(Document)
+	return nil
+}
+
+// CreatePatch returns the JSON Patch that turns Document from into
+// Document to. It is built from Diff so it uses add, remove and replace
+// operations only.
+func CreatePatch(from, to Document) []PatchOp {
+	changes := Diff(from, to)
+	ops := make([]PatchOp, len(changes))
+	for idx, c := range changes {
+		switch c.Type {
+		case ChangeAdded:
+			ops[idx] = PatchOp{Op: PatchAdd, Path: c.Path.Pointer(), Value: copyValue(c.New)}
+		case ChangeRemoved:
+			ops[idx] = PatchOp{Op: PatchRemove, Path: c.Path.Pointer()}
+		default:
+			ops[idx] = PatchOp{Op: PatchReplace, Path: c.Path.Pointer(), Value: copyValue(c.New)}
+		}
+	}
+	return ops
+}
+
+func applyPatchOp(root interface{}, op PatchOp) (interface{}, error) {
+	path, err := ParsePointer(op.Path)
+	if err != nil {
+		return root, err
+	}
+	switch op.Op {
+	case PatchAdd:
+		return patchAdd(root, path, copyValue(op.Value))
+	case PatchRemove:
+		return patchRemove(root, path)
+	case PatchReplace:
+		if _, err := patchGet(root, path); err != nil {
+			return root, err
+		}
+		if len(path) > 0 {
+			// the value exists so removing it first will not fail
+			root, _ = patchRemove(root, path)
+		}
+		return patchAdd(root, path, copyValue(op.Value))
+	case PatchMove, PatchCopy:
+		from, err := ParsePointer(op.From)
+		if err != nil {
+			return root, err
+		}
+		val, err := patchGet(root, from)
+		if err != nil {
+			return root, err
+		}
+		if op.Op == PatchCopy {
+			return patchAdd(root, path, copyValue(val))
+		}
+		if len(path) > len(from) && reflect.DeepEqual(path[:len(from)], from) {
+			return root, fmt.Errorf("%w: cannot move %s into itself", ErrInvalidPatch, op.From)
+		}
+		if root, err = patchRemove(root, from); err != nil {
+			return root, err
+		}
+		return patchAdd(root, path, val)
+	case PatchTest:
+		val, err := patchGet(root, path)
+		if err != nil {
+			return root, err
+		}
+		if !equalValues(val, op.Value) {
+			return root, fmt.Errorf("%w: %s is %s", ErrPatchTestFailed, op.Path, formatValue(val))
+		}
+		return root, nil
+	default:
+		return root, fmt.Errorf("%w: unknown op %q", ErrInvalidPatch, op.Op)
+	}
+}
+
+// pointerIndex parses a JSON Pointer array index, which must not have
+// leading zeros or a sign. When end is set "-" refers to the position after
+// the last item and len(list) is a valid index.
+func pointerIndex(seg string, n int, end bool) (int, error) {
+	if end && seg == "-" {
+		return n, nil
+	}
+	idx, err := strconv.Atoi(seg)
+	if err != nil || seg[0] == '+' || seg[0] == '-' || (len(seg) > 1 && seg[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid list index %q", ErrTypeConflict, seg)
+	}
+	if idx > n || (!end && idx == n) {
+		return 0, ErrIndexOutOfRange
+	}
+	return idx, nil
+}
+
+// patchAt navigates to the parent of path and replaces it with the result of
+// fn, which is called with the parent and the last path segment
+func patchAt(v interface{}, path Path, fn func(parent interface{}, key string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(v, path[0])
+	}
+	seg := path[0]
+	switch t := v.(type) {
+	case Document:
+		child, prs := t[seg]
+		if !prs {
+			return v, fmt.Errorf("%w: %s", ErrPathNotFound, seg)
+		}
+		nc, err := patchAt(child, path[1:], fn)
+		if err != nil {
+			return v, err
+		}
+		t[seg] = nc
+		return t, nil
+	case []interface{}:
+		idx, err := pointerIndex(seg, len(t), false)
+		if err != nil {
+			return v, err
+		}
+		nc, err := patchAt(t[idx], path[1:], fn)
+		if err != nil {
+			return v, err
+		}
+		t[idx] = nc
+		return t, nil
+	default:
+		return v, fmt.Errorf("%w: cannot index %T", ErrTypeConflict, v)
+	}
+}
+
+func patchGet(v interface{}, path Path) (interface{}, error) {
+	for _, seg := range path {
+		switch t := v.(type) {
+		case Document:
+			val, prs := t[seg]
+			if !prs {
+				return nil, fmt.Errorf("%w: %s", ErrPathNotFound, seg)
+			}
+			v = val
+		case []interface{}:
+			idx, err := pointerIndex(seg, len(t), false)
+			if err != nil {
+				return nil, err
+			}
+			v = t[idx]
+		default:
+			return nil, fmt.Errorf("%w: cannot index %T", ErrTypeConflict, v)
+		}
+	}
+	return v, nil
+}
+
+func patchAdd(root interface{}, path Path, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		if _, ok := value.(Document); !ok {
+			return root, fmt.Errorf("%w: document root must be an object, got %T", ErrTypeConflict, value)
+		}
+		return value, nil
+	}
+	return patchAt(root, path, func(parent interface{}, key string) (interface{}, error) {
+		switch t := parent.(type) {
+		case Document:
+			t[key] = value
+			return t, nil
+		case []interface{}:
+			idx, err := pointerIndex(key, len(t), true)
+			if err != nil {
+				return t, err
+			}
+			list := make([]interface{}, 0, len(t)+1)
+			list = append(list, t[:idx]...)
+			list = append(list, value)
+			return append(list, t[idx:]...), nil
+		default:
+			return parent, fmt.Errorf("%w: cannot add to %T", ErrTypeConflict, parent)
+		}
+	})
+}
+
+func patchRemove(root interface{}, path Path) (interface{}, error) {
+	if len(path) == 0 {
+		return root, fmt.Errorf("%w: cannot remove the document root", ErrInvalidPatch)
+	}
+	return patchAt(root, path, func(parent interface{}, key string) (interface{}, error) {
+		switch t := parent.(type) {
+		case Document:
+			if _, prs := t[key]; !prs {
+				return t, fmt.Errorf("%w: %s", ErrPathNotFound, key)
+			}
+			delete(t, key)
+			return t, nil
+		case []interface{}:
+			idx, err := pointerIndex(key, len(t), false)
+			if err != nil {
+				return t, err
+			}
+			list := make([]interface{}, 0, len(t)-1)
+			list = append(list, t[:idx]...)
+			return append(list, t[idx+1:]...), nil
+		default:
+			return parent, fmt.Errorf("%w: cannot remove from %T", ErrTypeConflict, parent)
+		}
+	})
+}
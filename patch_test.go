@@ -0,0 +1,163 @@
+package apidoc
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func mustDocument(t *testing.T, s string) Document {
+	t.Helper()
+
+	var doc Document
+	if err := json.Unmarshal([]byte(s), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func mustPatch(t *testing.T, s string) []PatchOp {
+	t.Helper()
+
+	var ops []PatchOp
+	if err := json.Unmarshal([]byte(s), &ops); err != nil {
+		t.Fatal(err)
+	}
+	return ops
+}
+
+// examples from RFC 6902 Appendix A
+func TestApplyPatchRFC6902(t *testing.T) {
+	cases := []struct {
+		doc, patch, exp string
+		err             error
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`, nil},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`, nil},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`, nil},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`, nil},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`, nil},
+		{
+			`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`, nil,
+		},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`, nil},
+		{
+			`{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`, nil,
+		},
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, "", ErrPatchTestFailed},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`, nil},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, "", ErrPathNotFound},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`, nil},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`, nil},
+		{`{"foo":"bar"}`, `[{"op":"copy","from":"/foo","path":"/baz"}]`, `{"foo":"bar","baz":"bar"}`, nil},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/2","value":"x"}]`, "", ErrIndexOutOfRange},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/01","value":"x"}]`, "", ErrTypeConflict},
+		{`{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`, "", ErrInvalidPatch},
+		{`{"foo":"bar"}`, `[{"op":"jump","path":"/foo"}]`, "", ErrInvalidPatch},
+		{`{"foo":"bar"}`, `[{"op":"replace","path":"","value":{"baz":1}}]`, `{"baz":1}`, nil},
+	}
+	for _, c := range cases {
+		doc := mustDocument(t, c.doc)
+		err := doc.ApplyPatch(mustPatch(t, c.patch))
+		if c.err != nil {
+			assert(t, errors.Is(err, c.err), "%s: expected %v got %v", c.patch, c.err, err)
+			equals(t, mustDocument(t, c.doc), doc)
+			continue
+		}
+		ok(t, err)
+		equals(t, mustDocument(t, c.exp), doc)
+	}
+}
+
+func TestApplyPatchAtomic(t *testing.T) {
+	doc := loadDeparture(t)
+	orig := *doc.Copy()
+
+	err := doc.ApplyPatch([]PatchOp{
+		{Op: PatchReplace, Path: "/rooms/0/availability/total", Value: 3.0},
+		{Op: PatchRemove, Path: "/start_address"},
+		{Op: PatchTest, Path: "/id", Value: "nope"},
+	})
+	var perr *PatchError
+	assert(t, errors.As(err, &perr), "expected *PatchError got %v", err)
+	equals(t, 2, perr.Index)
+	assert(t, errors.Is(err, ErrPatchTestFailed), "expected ErrPatchTestFailed got %v", err)
+	assert(t, doc.Equal(orig), "failed patch should leave the document unchanged")
+}
+
+func TestCreatePatch(t *testing.T) {
+	from := loadDeparture(t)
+	to := *from.Copy()
+	ok(t, to.SetPath(3.0, "rooms", "0", "availability", "total"))
+	ok(t, to.SetPath("FULL", "rooms", "0", "flags", "0"))
+	ok(t, to.DeletePath("start_address", "postal_zip"))
+	ok(t, to.DeletePath("lowest_pp2a_prices", "-1"))
+	ok(t, to.DeletePath("lowest_pp2a_prices", "0"))
+	ok(t, to.SetPath(Document{"id": "1", "a/b": "~"}, "promotion"))
+	ok(t, to.DeletePath("addons"))
+
+	ops := CreatePatch(from, to)
+	patched := *from.Copy()
+	ok(t, patched.ApplyPatch(ops))
+	assert(t, patched.Equal(to), "patched document should equal target")
+
+	// patches survive a JSON round trip
+	data, err := json.Marshal(ops)
+	ok(t, err)
+	var decoded []PatchOp
+	ok(t, json.Unmarshal(data, &decoded))
+	equals(t, ops, decoded)
+	patched = *from.Copy()
+	ok(t, patched.ApplyPatch(decoded))
+	assert(t, patched.Equal(to), "patched document should equal target")
+
+	equals(t, 0, len(CreatePatch(to, to)))
+}
+
+func TestPatchOpJSON(t *testing.T) {
+	data, err := json.Marshal([]PatchOp{
+		{Op: PatchAdd, Path: "/a", Value: nil},
+		{Op: PatchRemove, Path: "/b"},
+		{Op: PatchMove, Path: "/c", From: "/d"},
+	})
+	ok(t, err)
+	equals(t, `[{"op":"add","path":"/a","value":null},{"op":"remove","path":"/b"},{"op":"move","path":"/c","from":"/d"}]`, string(data))
+
+	var ops []PatchOp
+	ok(t, json.Unmarshal(data, &ops))
+	equals(t, []PatchOp{
+		{Op: PatchAdd, Path: "/a", Value: nil},
+		{Op: PatchRemove, Path: "/b"},
+		{Op: PatchMove, Path: "/c", From: "/d"},
+	}, ops)
+
+	// RFC 6902 section 4 requires the value member, null is not implied
+	for _, op := range []string{PatchAdd, PatchReplace, PatchTest} {
+		var decoded PatchOp
+		err := json.Unmarshal([]byte(`{"op":"`+op+`","path":"/x"}`), &decoded)
+		assert(t, errors.Is(err, ErrInvalidPatch), "expected ErrInvalidPatch for %s got %v", op, err)
+	}
+}
+
+func TestApplyPatchUseNumber(t *testing.T) {
+	var doc Document
+	ok(t, JSONOptions{UseNumber: true}.Unmarshal([]byte(`{"a":5,"b":[1.50,{"c":2e3}],"id":12345678901234567890}`), &doc))
+
+	// numbers are compared by value, whatever their representation
+	ok(t, doc.ApplyPatch(mustPatch(t, `[
+		{"op":"test","path":"/a","value":5},
+		{"op":"test","path":"/b","value":[1.5,{"c":2000}]},
+		{"op":"replace","path":"/a","value":6}
+	]`)))
+	equals(t, 6.0, doc["a"])
+
+	ops := []PatchOp{{Op: PatchTest, Path: "/id", Value: json.Number("12345678901234567891")}}
+	err := doc.ApplyPatch(ops)
+	assert(t, errors.Is(err, ErrPatchTestFailed), "expected ErrPatchTestFailed got %v", err)
+	err = doc.ApplyPatch(mustPatch(t, `[{"op":"test","path":"/b/0","value":"1.50"}]`))
+	assert(t, errors.Is(err, ErrPatchTestFailed), "expected ErrPatchTestFailed got %v", err)
+}
@@ -0,0 +1,521 @@
+package apidoc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PathWildcard is the path segment that matches every item of a list, or
+// every value of a Document
+const PathWildcard = "*"
+
+// errors returned by the path functions, usually wrapped in a *PathError
+var (
+	ErrInvalidPath     = errors.New("invalid path")
+	ErrPathNotFound    = errors.New("path not found")
+	ErrTypeConflict    = errors.New("type conflict")
+	ErrIndexOutOfRange = errors.New("index out of range")
+)
+
+// PathError records a failed SetPath or DeletePath operation along with the
+// path up to and including the segment that failed
+type PathError struct {
+	Op   string
+	Path Path
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Op, e.Path, e.Err.Error())
+}
+
+// Unwrap returns the underlying error, for use with errors.Is and errors.As
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// Path is a sequence of segments addressing a value nested inside a
+// Document. A segment is resolved against the container it is applied to:
+//
+// Document      - the segment is a key
+// []interface{} - the segment is an index, negative indexes count from the end
+//
+// The PathWildcard segment matches every item of either container.
+//
+// The string form of a Path uses dots between keys and brackets around
+// indexes, e.g. rooms[0].price_bands[-1].prices[*].amount
+type Path []string
+
+// ParsePath parses the string form of a Path. Keys containing the special
+// characters `.`, `[`, `]` or quotes may be written as a quoted segment,
+// e.g. start_address["postal.zip"]
+func ParsePath(s string) (Path, error) {
+	path := make(Path, 0, strings.Count(s, ".")+strings.Count(s, "[")+1)
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '.':
+			if i == 0 || i == len(s)-1 || s[i+1] == '.' || s[i+1] == '[' {
+				return nil, fmt.Errorf("%w: unexpected '.' at offset %d in %q", ErrInvalidPath, i, s)
+			}
+			i++
+		case '[':
+			seg, n, err := parseBracketSegment(s[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s at offset %d in %q", ErrInvalidPath, err.Error(), i, s)
+			}
+			path = append(path, seg)
+			i += n
+			if i < len(s) && s[i] != '.' && s[i] != '[' {
+				return nil, fmt.Errorf("%w: expected '.' or '[' at offset %d in %q", ErrInvalidPath, i, s)
+			}
+		default:
+			end := strings.IndexAny(s[i:], ".[")
+			if end < 0 {
+				end = len(s) - i
+			}
+			seg := s[i : i+end]
+			if strings.ContainsAny(seg, `]"'`) {
+				return nil, fmt.Errorf("%w: unexpected character in %q", ErrInvalidPath, seg)
+			}
+			path = append(path, seg)
+			i += end
+		}
+	}
+	return path, nil
+}
+
+// parseBracketSegment parses a single [index], [*] or ["key"] segment from
+// the start of s, returning the segment and the number of bytes consumed
+func parseBracketSegment(s string) (string, int, error) {
+	if len(s) < 3 {
+		return "", 0, errors.New("unterminated '['")
+	}
+	if quote := s[1]; quote == '"' || quote == '\'' {
+		var b strings.Builder
+		for i := 2; i < len(s); i++ {
+			switch c := s[i]; c {
+			case '\\':
+				if i+1 >= len(s) {
+					return "", 0, errors.New("unterminated escape")
+				}
+				i++
+				b.WriteByte(s[i])
+			case quote:
+				if i+1 >= len(s) || s[i+1] != ']' {
+					return "", 0, errors.New("expected ']' after quoted key")
+				}
+				return b.String(), i + 2, nil
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", 0, errors.New("unterminated quoted key")
+	}
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return "", 0, errors.New("unterminated '['")
+	}
+	seg := s[1:end]
+	if seg != PathWildcard {
+		if _, err := strconv.Atoi(seg); err != nil {
+			return "", 0, fmt.Errorf("expected index or '*' got %q", seg)
+		}
+	}
+	return seg, end + 1, nil
+}
+
+// String returns the dotted/bracketed form of the Path which can be parsed
+// back with ParsePath
+func (p Path) String() string {
+	var b strings.Builder
+	for idx, seg := range p {
+		switch {
+		case seg == PathWildcard || isPathIndex(seg):
+			b.WriteByte('[')
+			b.WriteString(seg)
+			b.WriteByte(']')
+		case seg == "" || strings.ContainsAny(seg, `.[]"'`):
+			b.WriteString(`["`)
+			b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(seg))
+			b.WriteString(`"]`)
+		default:
+			if idx > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(seg)
+		}
+	}
+	return b.String()
+}
+
+// Pointer returns the RFC 6901 JSON Pointer form of the Path, e.g.
+// /rooms/0/availability/total
+func (p Path) Pointer() string {
+	var b strings.Builder
+	escaper := strings.NewReplacer("~", "~0", "/", "~1")
+	for _, seg := range p {
+		b.WriteByte('/')
+		b.WriteString(escaper.Replace(seg))
+	}
+	return b.String()
+}
+
+// ParsePointer parses an RFC 6901 JSON Pointer into a Path, the empty
+// pointer refers to the whole document
+func ParsePointer(s string) (Path, error) {
+	if s == "" {
+		return Path{}, nil
+	}
+	if s[0] != '/' {
+		return nil, fmt.Errorf("%w: JSON pointer %q must start with '/'", ErrInvalidPath, s)
+	}
+	unescaper := strings.NewReplacer("~1", "/", "~0", "~")
+	parts := strings.Split(s[1:], "/")
+	path := make(Path, len(parts))
+	for i, part := range parts {
+		if strings.Contains(strings.NewReplacer("~0", "", "~1", "").Replace(part), "~") {
+			return nil, fmt.Errorf("%w: invalid escape in JSON pointer %q", ErrInvalidPath, s)
+		}
+		path[i] = unescaper.Replace(part)
+	}
+	return path, nil
+}
+
+// isPathIndex reports if the segment looks like a list index
+func isPathIndex(seg string) bool {
+	_, err := strconv.Atoi(seg)
+	return err == nil
+}
+
+// pathIndex resolves seg as an index into a list of length n, negative
+// indexes count back from the end of the list
+func pathIndex(seg string, n int) (int, bool) {
+	idx, err := strconv.Atoi(seg)
+	if err != nil {
+		return 0, false
+	}
+	if idx < 0 {
+		idx += n
+	}
+	if idx < 0 || idx >= n {
+		return 0, false
+	}
+	return idx, true
+}
+
+// appendPath returns a new Path with seg appended, never sharing the backing
+// array of p
+func appendPath(p Path, seg string) Path {
+	np := make(Path, len(p), len(p)+1)
+	copy(np, p)
+	return append(np, seg)
+}
+
+// PathMatch is a single value found by GetPathAll along with the concrete
+// path (no wildcards or negative indexes) that leads to it
+type PathMatch struct {
+	Path  Path
+	Value interface{}
+}
+
+// GetPathAll returns every value matched by the provided path, fanning out
+// over lists and Documents for each PathWildcard segment. Matches are
+// returned in list order, and in sorted key order for Documents.
+//
+// e.g. GetPathAll("rooms", "*", "price_bands", "0", "prices") would return
+// the prices of the first price band of every room in a departure
+func (d Document) GetPathAll(parts ...string) []PathMatch {
+	if len(parts) == 0 {
+		return nil
+	}
+	var matches []PathMatch
+	walkPath(d, nil, parts, func(path Path, val interface{}) {
+		matches = append(matches, PathMatch{Path: path, Value: val})
+	})
+	return matches
+}
+
+// walkPath calls fn for every value in v matched by parts
+func walkPath(v interface{}, prefix Path, parts []string, fn func(Path, interface{})) {
+	if len(parts) == 0 {
+		fn(prefix, v)
+		return
+	}
+	seg, rest := parts[0], parts[1:]
+	switch t := v.(type) {
+	case Document:
+		if seg == PathWildcard {
+			for _, k := range t.KeysSorted() {
+				walkPath(t[k], appendPath(prefix, k), rest, fn)
+			}
+			return
+		}
+		if val, prs := t[seg]; prs {
+			walkPath(val, appendPath(prefix, seg), rest, fn)
+		}
+	case []interface{}:
+		if seg == PathWildcard {
+			for i, item := range t {
+				walkPath(item, appendPath(prefix, strconv.Itoa(i)), rest, fn)
+			}
+			return
+		}
+		if idx, ok := pathIndex(seg, len(t)); ok {
+			walkPath(t[idx], appendPath(prefix, strconv.Itoa(idx)), rest, fn)
+		}
+	}
+}
+
+// getPath returns the first value in v matched by parts
+func getPath(v interface{}, parts []string) (interface{}, bool) {
+	if len(parts) == 0 {
+		return v, true
+	}
+	seg, rest := parts[0], parts[1:]
+	switch t := v.(type) {
+	case Document:
+		if seg == PathWildcard {
+			for _, k := range t.KeysSorted() {
+				if val, ok := getPath(t[k], rest); ok {
+					return val, true
+				}
+			}
+			return nil, false
+		}
+		val, prs := t[seg]
+		if !prs {
+			return nil, false
+		}
+		return getPath(val, rest)
+	case []interface{}:
+		if seg == PathWildcard {
+			for _, item := range t {
+				if val, ok := getPath(item, rest); ok {
+					return val, true
+				}
+			}
+			return nil, false
+		}
+		idx, ok := pathIndex(seg, len(t))
+		if !ok {
+			return nil, false
+		}
+		return getPath(t[idx], rest)
+	default:
+		return nil, false
+	}
+}
+
+// SetPath sets value at the provided path, creating intermediate Documents
+// for any missing (or null) keys along the way. An index equal to the length
+// of a list appends to that list, any other index must already exist. A
+// PathWildcard segment sets the value under every existing item. Document
+// and list values are copied before being stored.
+//
+// e.g. SetPath(3.0, "rooms", "0", "availability", "total")
+//
+// Only Documents are created, so an index segment after a missing key is a
+// key of the new Document: SetPath(1.0, "flags", "0") without flags sets
+// {"flags": {"0": 1}}, not a list.
+//
+// Errors are returned as *PathError wrapping ErrTypeConflict when a segment
+// cannot be applied to the value found (e.g. a key into a string, or a nil
+// Document) or ErrIndexOutOfRange. Changes made before an error is
+// encountered under a wildcard are not rolled back.
+func (d Document) SetPath(value interface{}, parts ...string) error {
+	if len(parts) == 0 {
+		return &PathError{Op: "set", Err: ErrInvalidPath}
+	}
+	if d == nil {
+		return &PathError{Op: "set", Path: Path(parts[:1]), Err: fmt.Errorf("%w: nil Document", ErrTypeConflict)}
+	}
+	_, err := setPath(d, nil, parts, value)
+	return err
+}
+
+// setPath sets value at parts inside v, returning the updated v which may be
+// a new slice if a list was appended to
+func setPath(v interface{}, prefix Path, parts []string, value interface{}) (interface{}, error) {
+	seg, rest := parts[0], parts[1:]
+	path := appendPath(prefix, seg)
+
+	// set returns what should be stored in place of child
+	set := func(child interface{}, path Path) (interface{}, error) {
+		if len(rest) == 0 {
+			return copyValue(value), nil
+		}
+		if doc, ok := child.(Document); child == nil || (ok && doc == nil) {
+			child = New()
+		}
+		return setPath(child, path, rest, value)
+	}
+
+	switch t := v.(type) {
+	case Document:
+		if seg == PathWildcard {
+			for _, k := range t.KeysSorted() {
+				val, err := set(t[k], appendPath(prefix, k))
+				if err != nil {
+					return t, err
+				}
+				t[k] = val
+			}
+			return t, nil
+		}
+		val, err := set(t[seg], path)
+		if err != nil {
+			return t, err
+		}
+		t[seg] = val
+		return t, nil
+	case []interface{}:
+		if seg == PathWildcard {
+			for i := range t {
+				val, err := set(t[i], appendPath(prefix, strconv.Itoa(i)))
+				if err != nil {
+					return t, err
+				}
+				t[i] = val
+			}
+			return t, nil
+		}
+		idx, err := strconv.Atoi(seg)
+		if err != nil {
+			return t, &PathError{Op: "set", Path: path, Err: fmt.Errorf("%w: list index %q", ErrTypeConflict, seg)}
+		}
+		if idx == len(t) {
+			val, err := set(nil, path)
+			if err != nil {
+				return t, err
+			}
+			return append(t, val), nil
+		}
+		idx, ok := pathIndex(seg, len(t))
+		if !ok {
+			return t, &PathError{Op: "set", Path: path, Err: ErrIndexOutOfRange}
+		}
+		val, err := set(t[idx], path)
+		if err != nil {
+			return t, err
+		}
+		t[idx] = val
+		return t, nil
+	default:
+		return v, &PathError{Op: "set", Path: path, Err: fmt.Errorf("%w: cannot index %T", ErrTypeConflict, v)}
+	}
+}
+
+// DeletePath removes the value at the provided path. Deleting an item from a
+// list shifts the following items down. A PathWildcard segment deletes under
+// every item the rest of the path exists in, skipping the others, as the
+// last segment it empties the container.
+//
+// Errors are returned as *PathError wrapping ErrPathNotFound when the path
+// does not exist (in any item under a wildcard), ErrTypeConflict or
+// ErrIndexOutOfRange.
+func (d Document) DeletePath(parts ...string) error {
+	if len(parts) == 0 {
+		return &PathError{Op: "delete", Err: ErrInvalidPath}
+	}
+	_, err := deletePath(d, nil, parts)
+	return err
+}
+
+// deletePath removes parts from v, returning the updated v which will be a
+// new slice if an item was removed from a list
+func deletePath(v interface{}, prefix Path, parts []string) (interface{}, error) {
+	seg, rest := parts[0], parts[1:]
+	path := appendPath(prefix, seg)
+
+	switch t := v.(type) {
+	case Document:
+		if seg == PathWildcard {
+			if len(rest) == 0 {
+				for k := range t {
+					delete(t, k)
+				}
+				return t, nil
+			}
+			found := false
+			for _, k := range t.KeysSorted() {
+				val, err := deletePath(t[k], appendPath(prefix, k), rest)
+				if isPathMissing(err) {
+					continue
+				}
+				if err != nil {
+					return t, err
+				}
+				t[k] = val
+				found = true
+			}
+			if !found {
+				return t, &PathError{Op: "delete", Path: appendPath(path, rest[0]), Err: ErrPathNotFound}
+			}
+			return t, nil
+		}
+		child, prs := t[seg]
+		if !prs {
+			return t, &PathError{Op: "delete", Path: path, Err: ErrPathNotFound}
+		}
+		if len(rest) == 0 {
+			delete(t, seg)
+			return t, nil
+		}
+		val, err := deletePath(child, path, rest)
+		if err != nil {
+			return t, err
+		}
+		t[seg] = val
+		return t, nil
+	case []interface{}:
+		if seg == PathWildcard {
+			if len(rest) == 0 {
+				return []interface{}{}, nil
+			}
+			found := false
+			for i := range t {
+				val, err := deletePath(t[i], appendPath(prefix, strconv.Itoa(i)), rest)
+				if isPathMissing(err) {
+					continue
+				}
+				if err != nil {
+					return t, err
+				}
+				t[i] = val
+				found = true
+			}
+			if !found {
+				return t, &PathError{Op: "delete", Path: appendPath(path, rest[0]), Err: ErrPathNotFound}
+			}
+			return t, nil
+		}
+		if !isPathIndex(seg) {
+			return t, &PathError{Op: "delete", Path: path, Err: fmt.Errorf("%w: list index %q", ErrTypeConflict, seg)}
+		}
+		idx, ok := pathIndex(seg, len(t))
+		if !ok {
+			return t, &PathError{Op: "delete", Path: path, Err: ErrIndexOutOfRange}
+		}
+		if len(rest) == 0 {
+			list := make([]interface{}, 0, len(t)-1)
+			list = append(list, t[:idx]...)
+			return append(list, t[idx+1:]...), nil
+		}
+		val, err := deletePath(t[idx], path, rest)
+		if err != nil {
+			return t, err
+		}
+		t[idx] = val
+		return t, nil
+	default:
+		return v, &PathError{Op: "delete", Path: path, Err: fmt.Errorf("%w: cannot index %T", ErrTypeConflict, v)}
+	}
+}
+
+// isPathMissing reports if err means that a path does not exist in a value,
+// as opposed to the path being invalid
+func isPathMissing(err error) bool {
+	return errors.Is(err, ErrPathNotFound) || errors.Is(err, ErrIndexOutOfRange) || errors.Is(err, ErrTypeConflict)
+}
@@ -0,0 +1,246 @@
+package apidoc
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	cases := []struct {
+		in  string
+		out Path
+	}{
+		{"", Path{}},
+		{"id", Path{"id"}},
+		{"start_address.country.name", Path{"start_address", "country", "name"}},
+		{"rooms[0].price_bands[-1].prices", Path{"rooms", "0", "price_bands", "-1", "prices"}},
+		{"rooms[*].code", Path{"rooms", "*", "code"}},
+		{"rooms.*.code", Path{"rooms", "*", "code"}},
+		{"[2][0]", Path{"2", "0"}},
+		{`a["b.c"]['d"e'].f`, Path{"a", "b.c", `d"e`, "f"}},
+		{`a["x\"y"]`, Path{"a", `x"y`}},
+	}
+	for _, c := range cases {
+		p, err := ParsePath(c.in)
+		ok(t, err)
+		equals(t, c.out, p)
+	}
+
+	for _, bad := range []string{".a", "a.", "a..b", "a.[0]", "a[", "a[x]", `a["b]`, "a]", "a[0]b", `a["b"]c`, "[*]x"} {
+		_, err := ParsePath(bad)
+		assert(t, errors.Is(err, ErrInvalidPath), "expected ErrInvalidPath for %q got %v", bad, err)
+	}
+}
+
+func TestPathString(t *testing.T) {
+	cases := []struct {
+		in  Path
+		out string
+	}{
+		{Path{"rooms", "0", "availability", "total"}, "rooms[0].availability.total"},
+		{Path{"rooms", "*", "code"}, "rooms[*].code"},
+		{Path{"0", "a"}, "[0].a"},
+		{Path{"a", "b.c", `x"y`}, `a["b.c"]["x\"y"]`},
+	}
+	for _, c := range cases {
+		equals(t, c.out, c.in.String())
+		p, err := ParsePath(c.out)
+		ok(t, err)
+		equals(t, c.in, p)
+	}
+}
+
+func TestGetPathLists(t *testing.T) {
+	doc := loadDeparture(t)
+
+	val, found := doc.GetPath("rooms", "0", "price_bands", "0", "prices", "0", "currency")
+	assert(t, found, "expected to find the first currency")
+	equals(t, "CAD", val)
+
+	val, found = doc.GetPath("rooms", "0", "price_bands", "0", "prices", "-1", "currency")
+	assert(t, found, "expected to find the last currency")
+	equals(t, "NZD", val)
+
+	val, found = doc.GetPath("rooms", "*", "availability", "total")
+	assert(t, found, "expected to find the room availability")
+	equals(t, 5.0, val)
+
+	_, found = doc.GetPath("rooms", "1")
+	assert(t, !found, "rooms[1] should be out of range")
+	_, found = doc.GetPath("rooms", "-2")
+	assert(t, !found, "rooms[-2] should be out of range")
+	_, found = doc.GetPath("rooms", "code")
+	assert(t, !found, "lists are not indexed by key")
+	_, found = doc.GetPath("id", "0")
+	assert(t, !found, "strings are not indexed")
+}
+
+func TestGetPathAll(t *testing.T) {
+	doc := loadDeparture(t)
+
+	p, err := ParsePath("rooms[*].price_bands[*].prices[*].currency")
+	ok(t, err)
+	matches := doc.GetPathAll(p...)
+	equals(t, 8, len(matches))
+	equals(t, "rooms[0].price_bands[0].prices[5].currency", matches[5].Path.String())
+	equals(t, "USD", matches[5].Value)
+
+	matches = doc.GetPathAll("lowest_pp2a_prices", "-1")
+	equals(t, 1, len(matches))
+	equals(t, Path{"lowest_pp2a_prices", "7"}, matches[0].Path)
+
+	matches = doc.GetPathAll("start_address", "country", "*")
+	equals(t, 3, len(matches))
+	equals(t, Path{"start_address", "country", "href"}, matches[0].Path)
+	equals(t, Path{"start_address", "country", "id"}, matches[1].Path)
+
+	equals(t, 0, len(doc.GetPathAll("rooms", "*", "nope")))
+	equals(t, 0, len(doc.GetPathAll()))
+}
+
+func TestSetPath(t *testing.T) {
+	doc := loadDeparture(t)
+
+	ok(t, doc.SetPath(3.0, "rooms", "0", "availability", "total"))
+	val, _ := doc.GetPath("rooms", "0", "availability", "total")
+	equals(t, 3.0, val)
+
+	// auto-vivification of intermediate Documents, nulls included
+	ok(t, doc.SetPath("V8W", "start_address", "postal_zip", "code"))
+	val, _ = doc.GetPath("start_address", "postal_zip", "code")
+	equals(t, "V8W", val)
+	ok(t, doc.SetPath(true, "meta", "sync", "dirty"))
+	equals(t, Document{"sync": Document{"dirty": true}}, doc["meta"])
+	doc["nil"] = Document(nil)
+	ok(t, doc.SetPath(true, "nil", "dirty"))
+	equals(t, Document{"dirty": true}, doc["nil"])
+
+	// only Documents are created, an index after a missing key is a key
+	ok(t, doc.SetPath(1.0, "counts", "0"))
+	equals(t, Document{"0": 1.0}, doc["counts"])
+
+	// negative indexes and appending to a list
+	ok(t, doc.SetPath("XXX", "lowest_pp2a_prices", "-1", "currency"))
+	val, _ = doc.GetPath("lowest_pp2a_prices", "7", "currency")
+	equals(t, "XXX", val)
+	ok(t, doc.SetPath("JPY", "lowest_pp2a_prices", "8", "currency"))
+	equals(t, 9, len(doc["lowest_pp2a_prices"].([]interface{})))
+	equals(t, Document{"currency": "JPY"}, doc["lowest_pp2a_prices"].([]interface{})[8])
+	ok(t, doc.SetPath("new", "flags", "0"))
+	equals(t, []interface{}{"new"}, doc["flags"])
+
+	// wildcards
+	ok(t, doc.SetPath("0.00", "rooms", "*", "price_bands", "*", "prices", "*", "deposit"))
+	for _, m := range doc.GetPathAll("rooms", "*", "price_bands", "*", "prices", "*", "deposit") {
+		equals(t, "0.00", m.Value)
+	}
+
+	// values are copied
+	embed := Document{"a": "b"}
+	ok(t, doc.SetPath(embed, "embed"))
+	embed["a"] = "c"
+	equals(t, Document{"a": "b"}, doc["embed"])
+}
+
+func TestSetPathErrors(t *testing.T) {
+	doc := loadDeparture(t)
+	orig := *doc.Copy()
+
+	var perr *PathError
+	err := doc.SetPath("x", "id", "nope")
+	assert(t, errors.Is(err, ErrTypeConflict), "expected ErrTypeConflict got %v", err)
+	assert(t, errors.As(err, &perr), "expected *PathError")
+	equals(t, "set", perr.Op)
+	equals(t, "id.nope", perr.Path.String())
+
+	err = doc.SetPath("x", "rooms", "code")
+	assert(t, errors.Is(err, ErrTypeConflict), "expected ErrTypeConflict got %v", err)
+	err = doc.SetPath("x", "rooms", "2")
+	assert(t, errors.Is(err, ErrIndexOutOfRange), "expected ErrIndexOutOfRange got %v", err)
+	err = doc.SetPath("x", "rooms", "-2")
+	assert(t, errors.Is(err, ErrIndexOutOfRange), "expected ErrIndexOutOfRange got %v", err)
+	err = doc.SetPath("x")
+	assert(t, errors.Is(err, ErrInvalidPath), "expected ErrInvalidPath got %v", err)
+
+	assert(t, doc.Equal(orig), "failed SetPath should not modify the document")
+
+	var nilDoc Document
+	err = nilDoc.SetPath(1.0, "a", "b")
+	assert(t, errors.Is(err, ErrTypeConflict), "expected ErrTypeConflict got %v", err)
+	assert(t, errors.As(err, &perr), "expected *PathError")
+	equals(t, "a", perr.Path.String())
+}
+
+func TestDeletePath(t *testing.T) {
+	doc := loadDeparture(t)
+
+	ok(t, doc.DeletePath("start_address", "postal_zip"))
+	_, found := doc.GetPath("start_address", "postal_zip")
+	assert(t, !found, "postal_zip should be deleted")
+
+	ok(t, doc.DeletePath("lowest_pp2a_prices", "0"))
+	prices := doc["lowest_pp2a_prices"].([]interface{})
+	equals(t, 7, len(prices))
+	equals(t, "AUD", prices[0].(Document)["currency"])
+	ok(t, doc.DeletePath("lowest_pp2a_prices", "-1"))
+	val, _ := doc.GetPath("lowest_pp2a_prices", "-1", "currency")
+	equals(t, "ZAR", val)
+
+	ok(t, doc.DeletePath("rooms", "*", "price_bands", "*", "prices", "*", "promotions"))
+	equals(t, 0, len(doc.GetPathAll("rooms", "*", "price_bands", "*", "prices", "*", "promotions")))
+	ok(t, doc.DeletePath("addons", "*"))
+	equals(t, []interface{}{}, doc["addons"])
+	ok(t, doc.DeletePath("tour", "*"))
+	equals(t, Document{}, doc["tour"])
+
+	err := doc.DeletePath("start_address", "nope", "x")
+	assert(t, errors.Is(err, ErrPathNotFound), "expected ErrPathNotFound got %v", err)
+	err = doc.DeletePath("rooms", "3")
+	assert(t, errors.Is(err, ErrIndexOutOfRange), "expected ErrIndexOutOfRange got %v", err)
+	err = doc.DeletePath("id", "x")
+	assert(t, errors.Is(err, ErrTypeConflict), "expected ErrTypeConflict got %v", err)
+}
+
+func TestDeletePathWildcardMixed(t *testing.T) {
+	// items without the key are skipped, not left half deleted
+	doc := Document{"items": []interface{}{
+		Document{"a": 1.0, "b": 2.0},
+		Document{"b": 3.0},
+		"text",
+		Document{"a": 4.0, "c": []interface{}{}},
+	}}
+	ok(t, doc.DeletePath("items", "*", "a"))
+	equals(t, Document{"items": []interface{}{
+		Document{"b": 2.0},
+		Document{"b": 3.0},
+		"text",
+		Document{"c": []interface{}{}},
+	}}, doc)
+
+	ok(t, doc.DeletePath("items", "*", "c", "*"))
+	ok(t, doc.DeletePath("*", "1", "b"))
+	equals(t, Document{}, doc["items"].([]interface{})[1])
+
+	// nothing to delete under any item
+	before := *doc.Copy()
+	err := doc.DeletePath("items", "*", "a")
+	assert(t, errors.Is(err, ErrPathNotFound), "expected ErrPathNotFound got %v", err)
+	err = doc.DeletePath("items", "*", "c", "0")
+	assert(t, errors.Is(err, ErrPathNotFound), "expected ErrPathNotFound got %v", err)
+	equals(t, before, doc)
+}
+
+func TestPathPointer(t *testing.T) {
+	p := Path{"rooms", "0", "a/b", "c~d"}
+	equals(t, "/rooms/0/a~1b/c~0d", p.Pointer())
+	parsed, err := ParsePointer(p.Pointer())
+	ok(t, err)
+	equals(t, p, parsed)
+	parsed, err = ParsePointer("")
+	ok(t, err)
+	equals(t, Path{}, parsed)
+	_, err = ParsePointer("rooms")
+	assert(t, errors.Is(err, ErrInvalidPath), "expected ErrInvalidPath")
+	_, err = ParsePointer("/a~2")
+	assert(t, errors.Is(err, ErrInvalidPath), "expected ErrInvalidPath")
+}
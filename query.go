@@ -0,0 +1,759 @@
+package apidoc
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Query is a compiled query expression that can be evaluated against a
+// Document, a []interface{} or any of the other value types documented on
+// Document. A Query is safe for concurrent use.
+//
+// The syntax is a small JMESPath/JSONPath style language:
+//
+//	rooms                 key lookup, the leading `$` is optional
+//	rooms.code            nested key lookup
+//	["postal.zip"]        quoted key lookup
+//	rooms[0]  rooms[-1]   list index, negative indexes count from the end
+//	rooms.0.code          list index in the dotted form accepted by ParsePath
+//	rooms[1:3]  [::2]     list slice [start:end:step]
+//	rooms[*]  rooms.*     every item of a list, or value of a Document
+//	rooms[?expr]          list items for which expr is true
+//
+// Filter expressions are evaluated against each list item, `@` refers to the
+// item itself and bare paths are relative to it:
+//
+//	price_bands[?min_age >= 18 && max_age < 40]
+//	flags[?@ == 'FULL']
+//	rooms[?!(availability.total < `3`)]
+//
+// Supported operators are == != < <= > >= && || ! and parentheses. Literals
+// are 'strings', numbers, true, false and null. A path containing wildcards,
+// slices or filters compares true if any of its values do.
+//
+// Every step fans out over the values produced by the previous step, so the
+// result of a Query is always a flat list of matches. Missing keys and
+// out of range indexes simply produce no match.
+type Query struct {
+	src   string
+	steps []queryStep
+}
+
+// QueryError is returned by CompileQuery for invalid query expressions
+type QueryError struct {
+	Query  string
+	Offset int
+	Msg    string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("invalid query %q at offset %d: %s", e.Query, e.Offset, e.Msg)
+}
+
+// CompileQuery parses a query expression so it can be evaluated many times
+func CompileQuery(expr string) (*Query, error) {
+	p := &queryParser{lex: queryLexer{src: expr}}
+	p.next()
+	steps, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+	return &Query{src: expr, steps: steps}, nil
+}
+
+// MustCompileQuery is like CompileQuery but panics if the expression is
+// invalid. It simplifies initialization of global queries.
+func MustCompileQuery(expr string) *Query {
+	q, err := CompileQuery(expr)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// String returns the source text of the query
+func (q *Query) String() string {
+	return q.src
+}
+
+// Eval returns every value in v matched by the query
+func (q *Query) Eval(v interface{}) []interface{} {
+	var vals []interface{}
+	runQuery(q.steps, queryNode{value: v}, false, func(n queryNode) {
+		vals = append(vals, n.value)
+	})
+	return vals
+}
+
+// EvalPaths returns every value in v matched by the query along with its
+// concrete path
+func (q *Query) EvalPaths(v interface{}) []PathMatch {
+	var matches []PathMatch
+	runQuery(q.steps, queryNode{value: v}, true, func(n queryNode) {
+		matches = append(matches, PathMatch{Path: n.path, Value: n.value})
+	})
+	return matches
+}
+
+// Query compiles and evaluates expr against the document, it is a shortcut
+// for one-off queries
+func (d Document) Query(expr string) ([]interface{}, error) {
+	q, err := CompileQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	return q.Eval(d), nil
+}
+
+// evaluation
+
+type queryNode struct {
+	path  Path
+	value interface{}
+}
+
+// child returns the node for the value under seg, only tracking the path
+// when requested
+func (n queryNode) child(seg string, val interface{}, track bool) queryNode {
+	if !track {
+		return queryNode{value: val}
+	}
+	return queryNode{path: appendPath(n.path, seg), value: val}
+}
+
+type queryStep interface {
+	apply(n queryNode, track bool, emit func(queryNode))
+}
+
+func runQuery(steps []queryStep, n queryNode, track bool, emit func(queryNode)) {
+	if len(steps) == 0 {
+		emit(n)
+		return
+	}
+	steps[0].apply(n, track, func(c queryNode) {
+		runQuery(steps[1:], c, track, emit)
+	})
+}
+
+type queryField struct {
+	name string
+}
+
+func (s queryField) apply(n queryNode, track bool, emit func(queryNode)) {
+	switch t := n.value.(type) {
+	case Document:
+		if val, prs := t[s.name]; prs {
+			emit(n.child(s.name, val, track))
+		}
+	case []interface{}:
+		// the same as GetPath
+		if idx, ok := pathIndex(s.name, len(t)); ok {
+			emit(n.child(strconv.Itoa(idx), t[idx], track))
+		}
+	}
+}
+
+type queryWildcard struct{}
+
+func (queryWildcard) apply(n queryNode, track bool, emit func(queryNode)) {
+	switch t := n.value.(type) {
+	case Document:
+		for _, k := range t.KeysSorted() {
+			emit(n.child(k, t[k], track))
+		}
+	case []interface{}:
+		for i, item := range t {
+			emit(n.child(strconv.Itoa(i), item, track))
+		}
+	}
+}
+
+type queryIndex struct {
+	index int
+}
+
+func (s queryIndex) apply(n queryNode, track bool, emit func(queryNode)) {
+	list, ok := n.value.([]interface{})
+	if !ok {
+		return
+	}
+	idx := s.index
+	if idx < 0 {
+		idx += len(list)
+	}
+	if idx >= 0 && idx < len(list) {
+		emit(n.child(strconv.Itoa(idx), list[idx], track))
+	}
+}
+
+type querySlice struct {
+	start, end *int
+	step       int
+}
+
+func (s querySlice) apply(n queryNode, track bool, emit func(queryNode)) {
+	list, ok := n.value.([]interface{})
+	if !ok {
+		return
+	}
+	bound := func(p *int, def int) int {
+		if p == nil {
+			return def
+		}
+		i := *p
+		if i < 0 {
+			i += len(list)
+		}
+		if i < 0 {
+			return 0
+		}
+		if i > len(list) {
+			return len(list)
+		}
+		return i
+	}
+	for i := bound(s.start, 0); i < bound(s.end, len(list)); i += s.step {
+		emit(n.child(strconv.Itoa(i), list[i], track))
+	}
+}
+
+type queryFilter struct {
+	cond queryCond
+}
+
+func (s queryFilter) apply(n queryNode, track bool, emit func(queryNode)) {
+	list, ok := n.value.([]interface{})
+	if !ok {
+		return
+	}
+	for i, item := range list {
+		if s.cond.test(item) {
+			emit(n.child(strconv.Itoa(i), item, track))
+		}
+	}
+}
+
+// filter expressions
+
+type queryCond interface {
+	test(v interface{}) bool
+}
+
+type queryOr struct {
+	left, right queryCond
+}
+
+func (c queryOr) test(v interface{}) bool {
+	return c.left.test(v) || c.right.test(v)
+}
+
+type queryAnd struct {
+	left, right queryCond
+}
+
+func (c queryAnd) test(v interface{}) bool {
+	return c.left.test(v) && c.right.test(v)
+}
+
+type queryNot struct {
+	cond queryCond
+}
+
+func (c queryNot) test(v interface{}) bool {
+	return !c.cond.test(v)
+}
+
+// queryTruthy tests that an operand has a value other than null, false, ""
+// or an empty list or Document
+type queryTruthy struct {
+	operand queryOperand
+}
+
+func (c queryTruthy) test(v interface{}) bool {
+	for _, val := range c.operand.values(v) {
+		if isTruthy(val) {
+			return true
+		}
+	}
+	return false
+}
+
+type queryCompare struct {
+	op          string
+	left, right queryOperand
+}
+
+func (c queryCompare) test(v interface{}) bool {
+	for _, l := range c.left.values(v) {
+		for _, r := range c.right.values(v) {
+			if compareOp(c.op, l, r) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type queryOperand interface {
+	values(v interface{}) []interface{}
+}
+
+type queryLiteral struct {
+	value interface{}
+}
+
+func (o queryLiteral) values(interface{}) []interface{} {
+	return []interface{}{o.value}
+}
+
+// queryRelative is a path relative to the filtered item, singular paths
+// (only key lookups and indexes) yield null when missing so that comparisons
+// like `field == null` or `field != 'x'` behave as expected
+type queryRelative struct {
+	steps    []queryStep
+	singular bool
+}
+
+func (o queryRelative) values(v interface{}) []interface{} {
+	var vals []interface{}
+	runQuery(o.steps, queryNode{value: v}, false, func(n queryNode) {
+		vals = append(vals, n.value)
+	})
+	if len(vals) == 0 && o.singular {
+		return []interface{}{nil}
+	}
+	return vals
+}
+
+func isTruthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != ""
+	case []interface{}:
+		return len(t) > 0
+	case Document:
+		return len(t) > 0
+	default:
+		return true
+	}
+}
+
+// queryNumber returns v as a float64 if it is a number
+func queryNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func compareOp(op string, l, r interface{}) bool {
+	lf, lnum := queryNumber(l)
+	rf, rnum := queryNumber(r)
+	switch op {
+	case "==":
+		if lnum && rnum {
+			return lf == rf
+		}
+		return reflect.DeepEqual(l, r)
+	case "!=":
+		if lnum && rnum {
+			return lf != rf
+		}
+		return !reflect.DeepEqual(l, r)
+	}
+	var cmp int
+	switch {
+	case lnum && rnum:
+		switch {
+		case lf < rf:
+			cmp = -1
+		case lf > rf:
+			cmp = 1
+		}
+	default:
+		ls, lstr := l.(string)
+		rs, rstr := r.(string)
+		if !lstr || !rstr {
+			return false
+		}
+		cmp = strings.Compare(ls, rs)
+	}
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// lexer
+
+type queryTokenKind int
+
+const (
+	queryEOF queryTokenKind = iota
+	queryIdent
+	queryString  // 'raw string'
+	queryQuoted  // "quoted identifier"
+	queryNumberT // number literal
+	queryPunct   // operators and punctuation
+)
+
+type queryToken struct {
+	kind queryTokenKind
+	text string
+	pos  int
+}
+
+type queryLexer struct {
+	src string
+	pos int
+}
+
+func (l *queryLexer) errorf(pos int, format string, args ...interface{}) error {
+	return &QueryError{Query: l.src, Offset: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func isQueryIdentByte(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		(!first && c >= '0' && c <= '9')
+}
+
+func (l *queryLexer) next() (queryToken, error) {
+	for l.pos < len(l.src) && (l.src[l.pos] == ' ' || l.src[l.pos] == '\t') {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return queryToken{kind: queryEOF, pos: start}, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case isQueryIdentByte(c, true):
+		for l.pos < len(l.src) && isQueryIdentByte(l.src[l.pos], false) {
+			l.pos++
+		}
+		return queryToken{kind: queryIdent, text: l.src[start:l.pos], pos: start}, nil
+	case c >= '0' && c <= '9' || c == '-' && l.pos+1 < len(l.src) && l.src[l.pos+1] >= '0' && l.src[l.pos+1] <= '9':
+		l.pos++
+		// a path segment such as the 0 of rooms.0.code is an index
+		segment := start > 0 && l.src[start-1] == '.'
+		for l.pos < len(l.src) && strings.IndexByte("0123456789.eE+-", l.src[l.pos]) >= 0 {
+			c := l.src[l.pos]
+			if segment && (c < '0' || c > '9') {
+				break
+			}
+			// only allow a sign directly after an exponent
+			if (c == '+' || c == '-') && l.src[l.pos-1] != 'e' && l.src[l.pos-1] != 'E' {
+				break
+			}
+			// and a '.' followed by a digit
+			if c == '.' && (l.pos+1 >= len(l.src) || l.src[l.pos+1] < '0' || l.src[l.pos+1] > '9') {
+				break
+			}
+			l.pos++
+		}
+		return queryToken{kind: queryNumberT, text: l.src[start:l.pos], pos: start}, nil
+	case c == '\'' || c == '"':
+		var b strings.Builder
+		for l.pos++; l.pos < len(l.src); l.pos++ {
+			switch ch := l.src[l.pos]; ch {
+			case '\\':
+				l.pos++
+				if l.pos >= len(l.src) {
+					return queryToken{}, l.errorf(start, "unterminated string")
+				}
+				b.WriteByte(l.src[l.pos])
+			case c:
+				l.pos++
+				kind := queryString
+				if c == '"' {
+					kind = queryQuoted
+				}
+				return queryToken{kind: kind, text: b.String(), pos: start}, nil
+			default:
+				b.WriteByte(ch)
+			}
+		}
+		return queryToken{}, l.errorf(start, "unterminated string")
+	case c == '`':
+		// JMESPath style literal, only scalars are supported
+		end := strings.IndexByte(l.src[l.pos+1:], '`')
+		if end < 0 {
+			return queryToken{}, l.errorf(start, "unterminated literal")
+		}
+		l.pos += end + 2
+		text := strings.TrimSpace(l.src[start+1 : l.pos-1])
+		if text == "true" || text == "false" || text == "null" {
+			return queryToken{kind: queryIdent, text: text, pos: start}, nil
+		}
+		if s, err := strconv.Unquote(text); err == nil && strings.HasPrefix(text, `"`) {
+			return queryToken{kind: queryString, text: s, pos: start}, nil
+		}
+		return queryToken{kind: queryNumberT, text: text, pos: start}, nil
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "&&", "||"} {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += 2
+			return queryToken{kind: queryPunct, text: op, pos: start}, nil
+		}
+	}
+	if strings.IndexByte(".[]*?@$():<>!", c) >= 0 {
+		l.pos++
+		return queryToken{kind: queryPunct, text: string(c), pos: start}, nil
+	}
+	return queryToken{}, l.errorf(start, "unexpected character %q", c)
+}
+
+// parser
+
+type queryParser struct {
+	lex queryLexer
+	tok queryToken
+	err error
+}
+
+func (p *queryParser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.next()
+}
+
+func (p *queryParser) is(text string) bool {
+	return p.err == nil && p.tok.kind == queryPunct && p.tok.text == text
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	if p.err != nil {
+		return p.err
+	}
+	return p.lex.errorf(p.tok.pos, format, args...)
+}
+
+func (p *queryParser) expect(text string) error {
+	if !p.is(text) {
+		return p.errorf("expected %q", text)
+	}
+	p.next()
+	return p.err
+}
+
+// parseQuery parses a whole query expression
+func (p *queryParser) parseQuery() ([]queryStep, error) {
+	if p.is("$") {
+		p.next()
+	}
+	steps, _, err := p.parseSteps(true)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != queryEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return steps, nil
+}
+
+// parseSteps parses a sequence of steps, the first of which may be a bare
+// identifier. It also reports whether every step was a key or index lookup.
+func (p *queryParser) parseSteps(leading bool) ([]queryStep, bool, error) {
+	var steps []queryStep
+	singular := true
+	if leading && p.err == nil && (p.tok.kind == queryIdent || p.tok.kind == queryQuoted) {
+		steps = append(steps, queryField{name: p.tok.text})
+		p.next()
+	} else if leading && p.is("*") {
+		steps = append(steps, queryWildcard{})
+		singular = false
+		p.next()
+	}
+	for p.err == nil {
+		switch {
+		case p.is("."):
+			p.next()
+			switch {
+			case p.is("*"):
+				steps = append(steps, queryWildcard{})
+				singular = false
+			case p.tok.kind == queryIdent || p.tok.kind == queryQuoted || p.tok.kind == queryNumberT:
+				steps = append(steps, queryField{name: p.tok.text})
+			default:
+				return nil, false, p.errorf("expected key after '.'")
+			}
+			p.next()
+		case p.is("["):
+			p.next()
+			step, single, err := p.parseBracket()
+			if err != nil {
+				return nil, false, err
+			}
+			steps = append(steps, step)
+			singular = singular && single
+		default:
+			return steps, singular, nil
+		}
+	}
+	return nil, false, p.err
+}
+
+// parseBracket parses the contents of [...] after the opening bracket
+func (p *queryParser) parseBracket() (queryStep, bool, error) {
+	switch {
+	case p.is("*"):
+		p.next()
+		return queryWildcard{}, false, p.expect("]")
+	case p.is("?"):
+		p.next()
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, false, err
+		}
+		return queryFilter{cond: cond}, false, p.expect("]")
+	case p.tok.kind == queryString || p.tok.kind == queryQuoted:
+		name := p.tok.text
+		p.next()
+		return queryField{name: name}, true, p.expect("]")
+	}
+
+	// index or slice
+	var parts [3]*int
+	n := 0
+	for {
+		if p.tok.kind == queryNumberT {
+			i, err := strconv.Atoi(p.tok.text)
+			if err != nil {
+				return nil, false, p.errorf("invalid index %q", p.tok.text)
+			}
+			parts[n] = &i
+			p.next()
+		}
+		if !p.is(":") {
+			break
+		}
+		if n++; n > 2 {
+			return nil, false, p.errorf("too many ':' in slice")
+		}
+		p.next()
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, false, err
+	}
+	if n == 0 {
+		if parts[0] == nil {
+			return nil, false, p.errorf("expected index")
+		}
+		return queryIndex{index: *parts[0]}, true, nil
+	}
+	step := 1
+	if parts[2] != nil {
+		step = *parts[2]
+	}
+	if step < 1 {
+		return nil, false, p.errorf("slice step must be positive")
+	}
+	return querySlice{start: parts[0], end: parts[1], step: step}, false, nil
+}
+
+func (p *queryParser) parseOr() (queryCond, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.is("||") {
+		p.next()
+		var right queryCond
+		right, err = p.parseAnd()
+		left = queryOr{left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *queryParser) parseAnd() (queryCond, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.is("&&") {
+		p.next()
+		var right queryCond
+		right, err = p.parseUnary()
+		left = queryAnd{left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *queryParser) parseUnary() (queryCond, error) {
+	switch {
+	case p.is("!"):
+		p.next()
+		cond, err := p.parseUnary()
+		return queryNot{cond: cond}, err
+	case p.is("("):
+		p.next()
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return cond, p.expect(")")
+	}
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.err == nil && p.tok.kind == queryPunct {
+		switch op := p.tok.text; op {
+		case "==", "!=", "<", "<=", ">", ">=":
+			p.next()
+			right, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return queryCompare{op: op, left: left, right: right}, nil
+		}
+	}
+	return queryTruthy{operand: left}, p.err
+}
+
+func (p *queryParser) parseOperand() (queryOperand, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	tok := p.tok
+	switch {
+	case tok.kind == queryString:
+		p.next()
+		return queryLiteral{value: tok.text}, p.err
+	case tok.kind == queryNumberT:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", tok.text)
+		}
+		p.next()
+		return queryLiteral{value: f}, p.err
+	case tok.kind == queryIdent && (tok.text == "true" || tok.text == "false"):
+		p.next()
+		return queryLiteral{value: tok.text == "true"}, p.err
+	case tok.kind == queryIdent && tok.text == "null":
+		p.next()
+		return queryLiteral{value: nil}, p.err
+	case p.is("@"):
+		p.next()
+		steps, singular, err := p.parseSteps(false)
+		return queryRelative{steps: steps, singular: singular}, err
+	case tok.kind == queryIdent || tok.kind == queryQuoted:
+		steps, singular, err := p.parseSteps(true)
+		return queryRelative{steps: steps, singular: singular}, err
+	}
+	return nil, p.errorf("expected value or path")
+}
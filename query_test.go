@@ -0,0 +1,131 @@
+package apidoc
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	doc := loadDeparture(t)
+
+	cases := []struct {
+		query string
+		exp   []interface{}
+	}{
+		{"id", []interface{}{"733048"}},
+		{"$.start_address.country.name", []interface{}{"Zimbabwe"}},
+		{"nope.nope", nil},
+		{"rooms[0].availability.total", []interface{}{5.0}},
+		{"lowest_pp2a_prices[-1].currency", []interface{}{"EUR"}},
+		{"lowest_pp2a_prices[1:3].currency", []interface{}{"AUD", "CHF"}},
+		{"lowest_pp2a_prices[::3].currency", []interface{}{"USD", "GBP", "ZAR"}},
+		{"lowest_pp2a_prices[-2:].currency", []interface{}{"ZAR", "EUR"}},
+		{"tour.*", []interface{}{"https://rest.gadventures.com/tours/23185", "23185"}},
+		{`tour["href"]`, []interface{}{"https://rest.gadventures.com/tours/23185"}},
+		{
+			"rooms[?availability.status=='AVAILABLE'].price_bands[*].prices[?currency=='USD'].amount",
+			[]interface{}{"1199.00"},
+		},
+		{"rooms[?availability.status=='SOLD_OUT'].code", nil},
+		{"rooms[?availability.total >= 5].code", []interface{}{"STANDARD"}},
+		{"rooms[?availability.total > `5`].code", nil},
+		{"rooms[?availability.male == null].code", []interface{}{"STANDARD"}},
+		{"rooms[?missing == null].code", []interface{}{"STANDARD"}},
+		{"rooms[?!(availability.total < 3)].code", []interface{}{"STANDARD"}},
+		{"rooms[?flags].code", nil},
+		{"rooms[?price_bands[*].prices[*].currency == 'ZAR'].code", []interface{}{"STANDARD"}},
+		{"requirements[?type=='CHECKIN' || code=='NATIONALITY'].code", []interface{}{
+			"NATIONALITY", "PASSPORT_EXPIRY_DATE", "PASSPORT_NUMBER",
+		}},
+		{"requirements[?type=='CHECKIN' && code!='PASSPORT_NUMBER'].code", []interface{}{"PASSPORT_EXPIRY_DATE"}},
+		{"requirements[?details].details[*].detail_type.code", []interface{}{"CONFIRMATION_ONLY", "ANY"}},
+		{"addons[?min_days > 0 && max_days <= 1].product.id", []interface{}{"2059"}},
+		{"lowest_pp2a_prices[*].currency[?@ < 'C']", nil},
+		{"addons[*].start_date", []interface{}{"2017-05-07", "2017-04-29", "2017-05-03", "2017-04-30", "2017-04-30"}},
+	}
+	for _, c := range cases {
+		q, err := CompileQuery(c.query)
+		ok(t, err)
+		equals(t, c.query, q.String())
+		assert(t, len(c.exp) == len(q.Eval(doc)), "%s: expected %v got %v", c.query, c.exp, q.Eval(doc))
+		if len(c.exp) > 0 {
+			equals(t, c.exp, q.Eval(doc))
+		}
+	}
+}
+
+func TestQueryEvalPaths(t *testing.T) {
+	doc := loadDeparture(t)
+
+	q := MustCompileQuery("rooms[?code=='STANDARD'].price_bands[*].prices[?currency=='GBP' || currency=='NZD'].amount")
+	matches := q.EvalPaths(doc)
+	equals(t, 2, len(matches))
+	equals(t, "rooms[0].price_bands[0].prices[6].amount", matches[0].Path.String())
+	equals(t, "799.00", matches[0].Value)
+	equals(t, "rooms[0].price_bands[0].prices[7].amount", matches[1].Path.String())
+
+	// paths returned are usable with GetPath
+	val, found := doc.GetPath(matches[1].Path...)
+	assert(t, found, "expected to find %s", matches[1].Path)
+	equals(t, matches[1].Value, val)
+}
+
+func TestQueryDottedIndex(t *testing.T) {
+	doc := loadDeparture(t)
+	for query, exp := range map[string][]interface{}{
+		"rooms.0.code":                          {"STANDARD"},
+		"lowest_pp2a_prices.-1.currency":        {"EUR"},
+		"rooms.0.price_bands.0.prices.6.amount": {"799.00"},
+		"rooms.1.code":                          nil,
+		"rooms[?availability.total > 4.5].code": {"STANDARD"},
+		"rooms[?availability.total > 5.5].code": nil,
+	} {
+		q, err := CompileQuery(query)
+		ok(t, err)
+		equals(t, exp, q.Eval(doc))
+
+		// the same as ParsePath and GetPath
+		if path, err := ParsePath(query); err == nil {
+			val, found := doc.GetPath(path...)
+			equals(t, len(exp) == 1, found)
+			if found {
+				equals(t, exp[0], val)
+			}
+		}
+	}
+	equals(t, []interface{}{9.0}, MustCompileQuery("matrix.1.0").Eval(sampleDoc()))
+	matches := MustCompileQuery("rooms.-1.code").EvalPaths(doc)
+	equals(t, 1, len(matches))
+	equals(t, "rooms[0].code", matches[0].Path.String())
+}
+
+func TestQueryValues(t *testing.T) {
+	list := []interface{}{"FULL", "GUARANTEED", 4.0, true, nil, Document{"a": 1.0}}
+	q := MustCompileQuery("[?@ == 'FULL' || @ == `4` || @ == true]")
+	equals(t, []interface{}{"FULL", 4.0, true}, q.Eval(list))
+	equals(t, []interface{}{"FULL"}, MustCompileQuery("[0]").Eval(list))
+	equals(t, []interface{}{1.0}, MustCompileQuery("[-1].a").Eval(list))
+	equals(t, 0, len(MustCompileQuery("a").Eval("scalar")))
+	equals(t, []interface{}{"scalar"}, MustCompileQuery("").Eval("scalar"))
+
+	res, err := Document{"x": list}.Query("x[?@]")
+	ok(t, err)
+	equals(t, []interface{}{"FULL", "GUARANTEED", 4.0, true, Document{"a": 1.0}}, res)
+}
+
+func TestQueryErrors(t *testing.T) {
+	for _, bad := range []string{
+		"rooms[", "rooms[?]", "rooms.", "rooms[0", "rooms[a]", "rooms[1:2:3:4]",
+		"rooms[::0]", "rooms[?code=='x'", "rooms[?code=='x]", "rooms ^", "rooms..code",
+		"rooms[?(code=='x']",
+	} {
+		_, err := CompileQuery(bad)
+		var qerr *QueryError
+		assert(t, errors.As(err, &qerr), "expected *QueryError for %q got %v", bad, err)
+	}
+
+	defer func() {
+		assert(t, recover() != nil, "expected MustCompileQuery to panic")
+	}()
+	MustCompileQuery("[")
+}
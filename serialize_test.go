@@ -1,7 +1,10 @@
 package apidoc
 
 import (
+	"bytes"
 	"encoding/json"
+	"math"
+	"strconv"
 	"testing"
 )
 
@@ -27,8 +30,95 @@ func TestEmptyList(t *testing.T) {
 
 	// test json encoding
 	obtained := string(data)
-	expected := `{"floatnum":6.500000,"intnum":6,"nil":null,"party":[],"txt":"bam\"bam"}`
+	expected := `{"floatnum":6.5,"intnum":6,"nil":null,"party":[],"txt":"bam\"bam"}`
 	if obtained != expected {
 		t.Errorf("Expected %s but got %s", expected, obtained)
 	}
 }
+
+func TestMarshalFloat64(t *testing.T) {
+	cases := []struct {
+		in  float64
+		out string
+	}{
+		{6.5, "6.5"},
+		{6, "6"},
+		{0, "0"},
+		{-0.5, "-0.5"},
+		{0.0000001, "1e-7"},
+		{0.000001, "0.000001"},
+		{1249.99, "1249.99"},
+		{0.30000000000000004, "0.30000000000000004"},
+		{1e20, "100000000000000000000"},
+		{1e21, "1e+21"},
+		{12345678901234567890, "12345678901234567000"},
+		{math.MaxFloat64, "1.7976931348623157e+308"},
+		{math.SmallestNonzeroFloat64, "5e-324"},
+	}
+	for _, c := range cases {
+		data, err := json.Marshal(Document{"n": c.in})
+		ok(t, err)
+		equals(t, `{"n":`+c.out+`}`, string(data))
+		// shortest representation still round trips
+		f, err := strconv.ParseFloat(c.out, 64)
+		ok(t, err)
+		equals(t, c.in, f)
+	}
+
+	for _, bad := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		_, err := json.Marshal(Document{"n": bad})
+		assert(t, err != nil, "expected error marshaling %v", bad)
+	}
+}
+
+func TestJSONUseNumber(t *testing.T) {
+	blob := []byte(`{"id":12345678901234567890,"amount":1249.00,"tiny":0.0000001,"list":[1.50,2e3]}`)
+
+	var doc Document
+	ok(t, JSONOptions{UseNumber: true}.Unmarshal(blob, &doc))
+	equals(t, json.Number("12345678901234567890"), doc["id"])
+	equals(t, json.Number("1249.00"), doc["amount"])
+	equals(t, []interface{}{json.Number("1.50"), json.Number("2e3")}, doc["list"])
+
+	data, err := json.Marshal(doc)
+	ok(t, err)
+	equals(t, `{"amount":1249.00,"id":12345678901234567890,"list":[1.50,2e3],"tiny":0.0000001}`, string(data))
+
+	// the default mode decodes float64
+	var fdoc Document
+	ok(t, JSONOptions{}.Unmarshal(blob, &fdoc))
+	equals(t, 1249.0, fdoc["amount"])
+	assert(t, !doc.Equal(fdoc), "number representations differ")
+
+	var other Document
+	ok(t, JSONOptions{UseNumber: true}.Unmarshal([]byte(`{"id":12345678901234567891,"amount":1249.00,"tiny":0.0000001,"list":[1.50,2e3]}`), &other))
+	assert(t, !doc.Equal(other), "large ids should not collide")
+
+	err = JSONOptions{UseNumber: true}.Unmarshal([]byte(`{"a":1} {"b":2}`), &doc)
+	assert(t, err != nil, "expected error for trailing data")
+	err = JSONOptions{UseNumber: true}.Unmarshal([]byte(`[1]`), &doc)
+	assert(t, err != nil, "expected error for non object")
+}
+
+func TestJSONInvalidNumber(t *testing.T) {
+	for _, n := range []string{"0", "-0", "12", "1.50", "-0.25", "2e3", "1E+30", "1e-7"} {
+		for _, o := range []JSONOptions{{}, {Canonical: true}} {
+			_, err := o.Marshal(Document{"n": json.Number(n)})
+			ok(t, err)
+		}
+	}
+	// empty numbers are written as 0, as by encoding/json
+	data, err := JSONOptions{}.Marshal(Document{"n": json.Number("")})
+	ok(t, err)
+	equals(t, `{"n":0}`, string(data))
+
+	for _, n := range []string{"abc", "-", "01", "1.", ".5", "+1", "1e", "1e+", "0x10", "NaN", "Infinity", "1 "} {
+		for _, o := range []JSONOptions{{}, {Canonical: true}} {
+			_, err := o.Marshal(Document{"n": []interface{}{json.Number(n)}})
+			assert(t, err != nil, "expected error for %q canonical %v", n, o.Canonical)
+		}
+		buf := new(bytes.Buffer)
+		err := JSONOptions{}.WriteOutJSON(buf, Document{"n": json.Number(n)})
+		assert(t, err != nil, "expected error writing out %q", n)
+	}
+}
@@ -0,0 +1,232 @@
+package apidoc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Encoder writes a stream of Documents, sharing a single header and codec
+// stream, so that large collections can be written one Document at a time.
+// The stream is read with a Decoder.
+type Encoder struct {
+	w    io.Writer
+	h    binaryHeader
+	cw   io.WriteCloser
+	buf  bytes.Buffer
+	size [binary.MaxVarintLen64]byte
+	err  error
+}
+
+// NewEncoder returns an Encoder writing to w using the options of
+// MarshalBinary
+func NewEncoder(w io.Writer) *Encoder {
+	return EncodeOptions{Checksum: true, KeyTable: true}.NewEncoder(w)
+}
+
+// NewEncoder returns an Encoder writing to w, each Document has its own key
+// table and checksum
+func (o EncodeOptions) NewEncoder(w io.Writer) *Encoder {
+	enc := &Encoder{w: w}
+	enc.h, enc.err = o.header()
+	if o.Indexed {
+		enc.err = errors.New("the indexed layout cannot be written by an Encoder")
+	}
+	enc.h.flags |= binaryFlagStream
+	return enc
+}
+
+// Encode writes d to the stream
+func (enc *Encoder) Encode(d Document) error {
+	if err := enc.start(); err != nil {
+		return err
+	}
+
+	enc.buf.Reset()
+	e := enc.h.encoder(&enc.buf)
+	if err := e.encodeDocument(d); err != nil {
+		// nothing was written so the stream can still be used
+		return err
+	}
+	l := binary.PutUvarint(enc.size[:], uint64(enc.buf.Len()))
+	if enc.h.flags&binaryFlagChecksum != 0 {
+		enc.buf.Write(binary.LittleEndian.AppendUint32(nil, crc32.Checksum(enc.buf.Bytes(), castagnoliTable)))
+	}
+	if _, err := enc.cw.Write(enc.size[:l]); err != nil {
+		enc.err = err
+		return err
+	}
+	if _, err := enc.cw.Write(enc.buf.Bytes()); err != nil {
+		enc.err = err
+		return err
+	}
+	return nil
+}
+
+// Flush writes any buffered Documents to the underlying writer, if the
+// Codec supports it
+func (enc *Encoder) Flush() error {
+	if err := enc.start(); err != nil {
+		return err
+	}
+	if f, ok := enc.cw.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			enc.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+// Close ends the stream, it does not close the underlying writer
+func (enc *Encoder) Close() error {
+	if err := enc.start(); err != nil {
+		return err
+	}
+	enc.err = errors.New("apidoc: Encoder is closed")
+	return enc.cw.Close()
+}
+
+// start writes the header, the first time it is called
+func (enc *Encoder) start() error {
+	if enc.err != nil || enc.cw != nil {
+		return enc.err
+	}
+	if _, err := enc.w.Write(enc.h.appendTo(nil)); err != nil {
+		enc.err = err
+		return err
+	}
+	enc.cw = enc.h.codec.NewWriter(enc.w)
+	return nil
+}
+
+// Decoder reads a stream of Documents written by an Encoder
+type Decoder struct {
+	r   *bufio.Reader
+	o   DecodeOptions
+	h   binaryHeader
+	cr  *bufio.Reader
+	err error
+}
+
+// NewDecoder returns a Decoder reading from r using the default limits of
+// DecodeOptions
+func NewDecoder(r io.Reader) *Decoder {
+	return DecodeOptions{}.NewDecoder(r)
+}
+
+// NewDecoder returns a Decoder reading from r, the limits apply to each
+// Document of the stream
+func (o DecodeOptions) NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r), o: o.withDefaults()}
+}
+
+// Decode reads the next Document of the stream into d, it returns io.EOF
+// at the end of the stream
+func (dec *Decoder) Decode(d *Document) error {
+	if err := dec.start(); err != nil {
+		return err
+	}
+
+	size, err := binary.ReadUvarint(dec.cr)
+	if err == io.EOF {
+		dec.err = io.EOF
+		return io.EOF
+	}
+	if err != nil {
+		return dec.fail(err)
+	}
+	if size > uint64(dec.o.MaxBytes) {
+		dec.err = &LimitError{Limit: "MaxBytes", Max: dec.o.MaxBytes}
+		return dec.err
+	}
+
+	lr := &io.LimitedReader{R: dec.cr, N: int64(size)}
+	var (
+		r  io.Reader = lr
+		br *bytes.Reader
+	)
+	if dec.h.flags&binaryFlagChecksum != 0 {
+		data, err := io.ReadAll(lr)
+		if err != nil {
+			return dec.fail(err)
+		}
+		if lr.N > 0 {
+			return dec.fail(io.ErrUnexpectedEOF)
+		}
+		var want [checksumSize]byte
+		if _, err := io.ReadFull(dec.cr, want[:]); err != nil {
+			return dec.fail(err)
+		}
+		if err := verifyChecksum(data, binary.LittleEndian.Uint32(want[:])); err != nil {
+			return dec.fail(err)
+		}
+		br = bytes.NewReader(data)
+		r = br
+	}
+	doc, err := decodeDocumentFrom(newDecoder(r, dec.h.dict, dec.o))
+	if err != nil {
+		return dec.fail(err)
+	}
+	if lr.N > 0 || (br != nil && br.Len() > 0) {
+		return dec.fail(errors.New("trailing data after Document"))
+	}
+	*d = doc
+	return nil
+}
+
+// fail stops the Decoder as the stream cannot be resynchronized
+func (dec *Decoder) fail(err error) error {
+	dec.err = decodeError(err)
+	return dec.err
+}
+
+// start reads the header, the first time it is called
+func (dec *Decoder) start() error {
+	if dec.err != nil || dec.cr != nil {
+		return dec.err
+	}
+
+	data := make([]byte, binaryHeaderSize, binaryHeaderSize+binary.MaxVarintLen32)
+	if _, err := io.ReadFull(dec.r, data); err != nil {
+		if err != io.EOF {
+			err = fmt.Errorf("%w: %s", ErrCorrupt, err.Error())
+		}
+		dec.err = err
+		return err
+	}
+	if !hasBinaryHeader(data) {
+		dec.err = fmt.Errorf("%w: missing header", ErrCorrupt)
+		return dec.err
+	}
+	if binaryFlags(data[6])&binaryFlagDictionary != 0 {
+		id, err := binary.ReadUvarint(dec.r)
+		if err != nil {
+			dec.err = fmt.Errorf("%w: invalid dictionary ID", ErrCorrupt)
+			return dec.err
+		}
+		data = binary.AppendUvarint(data, id)
+	}
+	h, _, err := parseBinaryHeader(data)
+	if err != nil {
+		dec.err = err
+		return err
+	}
+	if h.flags&binaryFlagStream == 0 {
+		dec.err = errors.New("binary payload is a single Document, it is read with UnmarshalBinary")
+		return dec.err
+	}
+
+	cr, err := h.codec.NewReader(dec.r)
+	if err != nil {
+		dec.err = fmt.Errorf("%w: %s: %s", ErrCorrupt, h.codec.Name(), err.Error())
+		return dec.err
+	}
+	dec.h = h
+	dec.cr = bufio.NewReader(cr)
+	return nil
+}
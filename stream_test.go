@@ -0,0 +1,96 @@
+package apidoc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+)
+
+func TestEncoderDecoder(t *testing.T) {
+	departure := loadDeparture(t)
+	docs := make([]Document, 100)
+	for i := range docs {
+		docs[i] = *departure.Copy()
+		docs[i]["id"] = strconv.Itoa(i)
+	}
+
+	for name, o := range map[string]EncodeOptions{
+		"default":    {Checksum: true, KeyTable: true},
+		"plain":      {Codec: CodecNone},
+		"dictionary": {Codec: CodecNone, Checksum: true, Dictionary: DictionaryGAPI},
+		"gzip":       {Codec: gzipCodec{}},
+	} {
+		buf := new(bytes.Buffer)
+		enc := o.NewEncoder(buf)
+		for _, doc := range docs {
+			ok(t, enc.Encode(doc))
+		}
+		ok(t, enc.Flush())
+		ok(t, enc.Close())
+		assert(t, enc.Encode(departure) != nil, "%s: expected error encoding to a closed Encoder", name)
+
+		dec := NewDecoder(buf)
+		for i := range docs {
+			var doc Document
+			ok(t, dec.Decode(&doc))
+			assert(t, docs[i].Equal(doc), "%s: document %d should round trip", name, i)
+		}
+		var doc Document
+		equals(t, io.EOF, dec.Decode(&doc))
+		equals(t, io.EOF, dec.Decode(&doc))
+	}
+}
+
+func TestEncoderEmpty(t *testing.T) {
+	buf := new(bytes.Buffer)
+	ok(t, NewEncoder(buf).Close())
+	var doc Document
+	equals(t, io.EOF, NewDecoder(buf).Decode(&doc))
+	equals(t, io.EOF, NewDecoder(new(bytes.Buffer)).Decode(&doc))
+}
+
+func TestDecoderErrors(t *testing.T) {
+	departure := loadDeparture(t)
+	buf := new(bytes.Buffer)
+	enc := EncodeOptions{Codec: CodecNone, Checksum: true}.NewEncoder(buf)
+	ok(t, enc.Encode(departure))
+	ok(t, enc.Encode(departure))
+	ok(t, enc.Close())
+	stream := buf.Bytes()
+
+	// streams and single Documents are not interchangeable
+	var doc Document
+	assert(t, doc.UnmarshalBinary(stream) != nil, "expected error unmarshaling a stream")
+	data, err := departure.MarshalBinary()
+	ok(t, err)
+	assert(t, NewDecoder(bytes.NewReader(data)).Decode(&doc) != nil, "expected error decoding a single Document")
+
+	bad := append([]byte{}, stream...)
+	bad[bytes.LastIndex(bad, []byte("Zimbabwe"))] ^= 0xFF
+	dec := NewDecoder(bytes.NewReader(bad))
+	ok(t, dec.Decode(&doc))
+	err = dec.Decode(&doc)
+	assert(t, errors.Is(err, ErrCorrupt), "expected ErrCorrupt got %v", err)
+	equals(t, err, dec.Decode(&doc))
+
+	// a corrupt length is caught by the checksum, not by the limits
+	bad = append([]byte{}, stream...)
+	bad[bytes.LastIndex(bad, append(binary.LittleEndian.AppendUint64([]byte{byte(encodeTypeString)}, 8), "Zimbabwe"...))+4] ^= 0xFF
+	dec = NewDecoder(bytes.NewReader(bad))
+	ok(t, dec.Decode(&doc))
+	err = dec.Decode(&doc)
+	var limit *LimitError
+	assert(t, errors.Is(err, ErrCorrupt) && !errors.As(err, &limit), "expected checksum error got %v", err)
+
+	dec = NewDecoder(bytes.NewReader(stream[:len(stream)-10]))
+	ok(t, dec.Decode(&doc))
+	err = dec.Decode(&doc)
+	assert(t, errors.Is(err, ErrCorrupt), "expected ErrCorrupt for truncated stream got %v", err)
+
+	var le *LimitError
+	err = DecodeOptions{MaxBytes: 100}.NewDecoder(bytes.NewReader(stream)).Decode(&doc)
+	assert(t, errors.As(err, &le), "expected LimitError got %v", err)
+}
@@ -0,0 +1,237 @@
+package apidoc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/golang/snappy"
+)
+
+// TokenType is the type of a Token
+type TokenType uint8
+
+// the types of Token
+const (
+	TokenInvalid TokenType = iota
+	TokenDocumentStart
+	TokenDocumentEnd
+	TokenListStart
+	TokenListEnd
+	TokenKey
+	TokenValue
+)
+
+// String representation of TokenType
+func (t TokenType) String() string {
+	switch t {
+	case TokenDocumentStart:
+		return "DocumentStart"
+	case TokenDocumentEnd:
+		return "DocumentEnd"
+	case TokenListStart:
+		return "ListStart"
+	case TokenListEnd:
+		return "ListEnd"
+	case TokenKey:
+		return "Key"
+	case TokenValue:
+		return "Value"
+	default:
+		return fmt.Sprintf("Unknown(%d)", t)
+	}
+}
+
+// Token is a single item of the binary encoding of a Document
+type Token struct {
+	Type TokenType
+	// Key is set for TokenKey
+	Key string
+	// Value is set for TokenValue, it is a string, float64, json.Number,
+	// bool or nil
+	Value interface{}
+}
+
+// TokenReader reads the binary encoding of a Document one Token at a time,
+// so that parts of a payload can be read without decoding all of it.
+//
+// The checksum of the payload is not verified, as it covers the whole
+// Document.
+type TokenReader struct {
+	d *decoder
+	// stack holds the Documents and lists which have been started
+	stack []encodeType
+	// key is set after a TokenKey, as its value is next
+	key  bool
+	last TokenType
+	done bool
+	err  error
+}
+
+// NewTokenReader returns a TokenReader for a payload written by
+// MarshalBinary, using the default limits of DecodeOptions
+func NewTokenReader(data []byte) (*TokenReader, error) {
+	return DecodeOptions{}.NewTokenReader(data)
+}
+
+// NewTokenReader returns a TokenReader for a payload written by
+// MarshalBinary or EncodeOptions.Marshal
+func (o DecodeOptions) NewTokenReader(data []byte) (*TokenReader, error) {
+	if hasBinaryHeader(data) {
+		h, body, _, err := payloadBody(data)
+		if err != nil {
+			return nil, err
+		}
+		if h.flags&binaryFlagIndexed != 0 {
+			return nil, errors.New("binary payload is indexed, it is read with a LazyDocument")
+		}
+		r, err := h.codec.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrCorrupt, h.codec.Name(), err.Error())
+		}
+		return &TokenReader{d: newDecoder(r, h.dict, o)}, nil
+	}
+	if len(data) >= 4 && serializationType(binary.LittleEndian.Uint32(data)) == serBinary {
+		r := snappy.NewReader(bytes.NewReader(data[4:]))
+		return &TokenReader{d: newDecoder(r, nil, o)}, nil
+	}
+	return nil, errors.New("binary payload is legacy JSON, it cannot be read as tokens")
+}
+
+// Next returns the next Token, or io.EOF once the Document has ended
+func (t *TokenReader) Next() (Token, error) {
+	if t.err != nil {
+		return Token{}, t.err
+	}
+	if t.done {
+		return Token{}, io.EOF
+	}
+
+	typ, val, err := t.d.nextItem()
+	if err != nil {
+		return Token{}, t.fail(err)
+	}
+	if len(t.stack) == 0 && typ != encodeTypeDocumentStart {
+		return Token{}, t.fail(fmt.Errorf("expected DocumentStart got %v", typ))
+	}
+
+	// in a Document a key or its end is next
+	if t.inDocument() && !t.key {
+		switch typ {
+		case encodeTypeString, encodeTypeKeyDef, encodeTypeKeyRef:
+			if err := t.d.element(); err != nil {
+				return Token{}, t.fail(err)
+			}
+			t.key = true
+			return t.token(Token{Type: TokenKey, Key: val.(string)}), nil
+		case encodeTypeDocumentEnd:
+			t.pop()
+			return t.token(Token{Type: TokenDocumentEnd}), nil
+		default:
+			return Token{}, t.fail(fmt.Errorf("expected key got %v", typ))
+		}
+	}
+
+	if typ == encodeTypeListEnd && !t.key && !t.inDocument() {
+		t.pop()
+		return t.token(Token{Type: TokenListEnd}), nil
+	}
+	// a value, counted when it is a list item as keys are counted instead
+	if !t.key && len(t.stack) > 0 {
+		if err := t.d.element(); err != nil {
+			return Token{}, t.fail(err)
+		}
+	}
+	t.key = false
+	switch typ {
+	case encodeTypeDocumentStart, encodeTypeListStart:
+		if err := t.d.enter(); err != nil {
+			return Token{}, t.fail(err)
+		}
+		t.stack = append(t.stack, typ)
+		if typ == encodeTypeListStart {
+			return t.token(Token{Type: TokenListStart}), nil
+		}
+		return t.token(Token{Type: TokenDocumentStart}), nil
+	case encodeTypeString, encodeTypeBool, encodeTypeFloat64,
+		encodeTypeNumber, encodeTypeInt, encodeTypeNil:
+		return t.token(Token{Type: TokenValue, Value: val}), nil
+	default:
+		return Token{}, t.fail(fmt.Errorf("expected value got %v", typ))
+	}
+}
+
+// Skip skips the value of the last TokenKey, or the rest of the Document or
+// list started by the last Token, including its end. Otherwise Skip does
+// nothing.
+func (t *TokenReader) Skip() error {
+	if t.err != nil {
+		return t.err
+	}
+
+	switch t.last {
+	case TokenKey:
+		t.key = false
+		t.last = TokenValue
+		typ, err := t.d.skipItem()
+		if err != nil {
+			return t.fail(err)
+		}
+		if typ == encodeTypeDocumentStart || typ == encodeTypeListStart {
+			return t.skipNested()
+		}
+		return nil
+	case TokenDocumentStart, TokenListStart:
+		if err := t.skipNested(); err != nil {
+			return err
+		}
+		if t.last == TokenDocumentStart {
+			t.last = TokenDocumentEnd
+		} else {
+			t.last = TokenListEnd
+		}
+		t.pop()
+		return nil
+	}
+	return nil
+}
+
+// skipNested skips items until the Document or list just started has ended
+func (t *TokenReader) skipNested() error {
+	for level := 1; level > 0; {
+		typ, err := t.d.skipItem()
+		if err != nil {
+			return t.fail(err)
+		}
+		switch typ {
+		case encodeTypeDocumentStart, encodeTypeListStart:
+			level++
+		case encodeTypeDocumentEnd, encodeTypeListEnd:
+			level--
+		}
+	}
+	return nil
+}
+
+func (t *TokenReader) inDocument() bool {
+	return len(t.stack) > 0 && t.stack[len(t.stack)-1] == encodeTypeDocumentStart
+}
+
+func (t *TokenReader) pop() {
+	t.stack = t.stack[:len(t.stack)-1]
+	t.d.leave()
+	t.done = len(t.stack) == 0
+}
+
+func (t *TokenReader) token(tok Token) Token {
+	t.last = tok.Type
+	return tok
+}
+
+// fail stops the TokenReader as it cannot continue after an error
+func (t *TokenReader) fail(err error) error {
+	t.err = decodeError(err)
+	return t.err
+}
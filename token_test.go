@@ -0,0 +1,105 @@
+package apidoc
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestTokenReader(t *testing.T) {
+	doc := Document{"a": []interface{}{1.0, "x", Document{"c": nil}, []interface{}{}}}
+	data, err := doc.MarshalBinary()
+	ok(t, err)
+	tr, err := NewTokenReader(data)
+	ok(t, err)
+
+	expected := []Token{
+		{Type: TokenDocumentStart},
+		{Type: TokenKey, Key: "a"},
+		{Type: TokenListStart},
+		{Type: TokenValue, Value: 1.0},
+		{Type: TokenValue, Value: "x"},
+		{Type: TokenDocumentStart},
+		{Type: TokenKey, Key: "c"},
+		{Type: TokenValue, Value: nil},
+		{Type: TokenDocumentEnd},
+		{Type: TokenListStart},
+		{Type: TokenListEnd},
+		{Type: TokenListEnd},
+		{Type: TokenDocumentEnd},
+	}
+	for _, exp := range expected {
+		tok, err := tr.Next()
+		ok(t, err)
+		equals(t, exp, tok)
+	}
+	_, err = tr.Next()
+	equals(t, io.EOF, err)
+}
+
+func TestTokenReaderSkip(t *testing.T) {
+	doc := loadDeparture(t)
+	data, err := doc.MarshalBinary()
+	ok(t, err)
+
+	// read only a couple of top level values
+	for _, payload := range [][]byte{data, legacySnappyPayload(t, doc)} {
+		tr, err := NewTokenReader(payload)
+		ok(t, err)
+		tok, err := tr.Next()
+		ok(t, err)
+		equals(t, TokenDocumentStart, tok.Type)
+		found := make(Document)
+		for {
+			tok, err := tr.Next()
+			ok(t, err)
+			if tok.Type == TokenDocumentEnd {
+				break
+			}
+			equals(t, TokenKey, tok.Type)
+			if tok.Key != "id" && tok.Key != "date_last_modified" {
+				ok(t, tr.Skip())
+				continue
+			}
+			val, err := tr.Next()
+			ok(t, err)
+			found[tok.Key] = val.Value
+		}
+		equals(t, Document{"id": "733048", "date_last_modified": "2017-02-08T17:59:06Z"}, found)
+		_, err = tr.Next()
+		equals(t, io.EOF, err)
+	}
+
+	// skipping a started Document skips to its end
+	tr, err := NewTokenReader(data)
+	ok(t, err)
+	_, err = tr.Next()
+	ok(t, err)
+	ok(t, tr.Skip())
+	_, err = tr.Next()
+	equals(t, io.EOF, err)
+}
+
+func TestTokenReaderErrors(t *testing.T) {
+	doc := loadDeparture(t)
+	_, err := NewTokenReader(legacyJSONPayload(t, doc))
+	assert(t, err != nil, "expected error for legacy JSON payload")
+
+	data, err := EncodeOptions{Codec: CodecNone}.Marshal(doc)
+	ok(t, err)
+	tr, err := NewTokenReader(data[:len(data)/2])
+	ok(t, err)
+	for err == nil {
+		_, err = tr.Next()
+	}
+	assert(t, errors.Is(err, ErrCorrupt), "expected ErrCorrupt got %v", err)
+	equals(t, err, tr.Skip())
+
+	var le *LimitError
+	tr, err = DecodeOptions{MaxDepth: 1}.NewTokenReader(data)
+	ok(t, err)
+	for err == nil {
+		_, err = tr.Next()
+	}
+	assert(t, errors.As(err, &le), "expected LimitError got %v", err)
+}
@@ -1,5 +1,11 @@
 package apidoc
 
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+)
+
 // copy utils
 
 func copySlice(s []interface{}) []interface{} {
@@ -20,3 +26,64 @@ func copyValue(v interface{}) interface{} {
 		return t
 	}
 }
+
+// equality utils
+
+// equalValues compares values the same as reflect.DeepEqual, except that
+// numbers are compared by value when either side is a json.Number, so
+// json.Number("5") is equal to float64(5) and json.Number("5.0")
+func equalValues(a, b interface{}) bool {
+	switch at := a.(type) {
+	case Document:
+		bt, ok := b.(Document)
+		if !ok || len(at) != len(bt) {
+			return false
+		}
+		for k, av := range at {
+			bv, prs := bt[k]
+			if !prs || !equalValues(av, bv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bt, ok := b.([]interface{})
+		if !ok || len(at) != len(bt) {
+			return false
+		}
+		for i := range at {
+			if !equalValues(at[i], bt[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		return equalNumbers(at, b)
+	}
+	if bn, ok := b.(json.Number); ok {
+		return equalNumbers(bn, a)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// equalNumbers compares n with v by value. Two json.Numbers are compared
+// exactly, a json.Number and a float64 as the float64 the json.Number would
+// have been decoded as.
+func equalNumbers(n json.Number, v interface{}) bool {
+	switch m := v.(type) {
+	case json.Number:
+		if n == m {
+			return true
+		}
+		// enough precision to tell apart decimals of this length
+		prec := uint(len(n)+len(m))*4 + 64
+		x, xok := new(big.Float).SetPrec(prec).SetString(string(n))
+		y, yok := new(big.Float).SetPrec(prec).SetString(string(m))
+		return xok && yok && x.Cmp(y) == 0
+	case float64:
+		f, err := n.Float64()
+		return err == nil && f == m
+	default:
+		return false
+	}
+}